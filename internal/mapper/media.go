@@ -27,15 +27,42 @@ func MediaRecordToMediaCatalog(m datasource.MediaRecord) models.MediaCatalog {
 	if m.VideoUrl.Valid {
 		c.VideoUrl = m.VideoUrl.String
 	}
+	if m.Width.Valid {
+		c.Width = uint(m.Width.Int32)
+	}
+	if m.Height.Valid {
+		c.Height = uint(m.Height.Int32)
+	}
+	if m.Codec.Valid {
+		c.Codec = m.Codec.String
+	}
+	if m.Bitrate.Valid {
+		c.Bitrate = uint64(m.Bitrate.Int64)
+	}
+	if m.FrameRate.Valid {
+		c.FrameRate = m.FrameRate.Float64
+	}
 
 	mediaPath := m.GetMediaPath()
 	if mediaPath.Valid {
 		c.MediaPath = mediaPath.String
 	}
+	filePath := m.GetFilePath()
+	if filePath.Valid {
+		c.FilePath = filePath.String
+	}
 	thumbnailPath := m.GetThumbnailPath()
 	if thumbnailPath.Valid {
 		c.ThumbPath = thumbnailPath.String
 	}
+	previewPath := m.GetPreviewPath()
+	if previewPath.Valid {
+		c.PreviewPath = previewPath.String
+	}
+	spritePath := m.GetSpritePath()
+	if spritePath.Valid {
+		c.SpritePath = spritePath.String
+	}
 
 	return c
 }
@@ -69,10 +96,22 @@ func MediaRecordToMediaData(m datasource.MediaRecord) mediadata.MediaData {
 	if mediaPath.Valid {
 		md.MediaPath = mediaPath.String
 	}
+	filePath := m.GetFilePath()
+	if filePath.Valid {
+		md.FilePath = filePath.String
+	}
 	thumbnailPath := m.GetThumbnailPath()
 	if thumbnailPath.Valid {
 		md.ThumbPath = thumbnailPath.String
 	}
+	previewPath := m.GetPreviewPath()
+	if previewPath.Valid {
+		md.PreviewPath = previewPath.String
+	}
+	spritePath := m.GetSpritePath()
+	if spritePath.Valid {
+		md.SpritePath = spritePath.String
+	}
 
 	return md
 }
@@ -92,3 +131,45 @@ func MediaRecordSetListToMediaDataSetList(mediaSetList [][]datasource.MediaRecor
 	}
 	return setList
 }
+
+func HashClusterMemberToDuplicatedMedia(m datasource.HashClusterMember) models.DuplicatedMedia {
+	d := models.DuplicatedMedia{
+		MediaData:      MediaRecordToMediaData(m.MediaRecord),
+		Distance:       m.Distance,
+		Transform:      m.Transform,
+		Representative: m.Representative,
+	}
+
+	if m.ContentLength.Valid {
+		d.ContentLength = uint64(m.ContentLength.Int64)
+	}
+
+	return d
+}
+
+func HashClusterListToDuplicatedMediaSetList(clusters [][]datasource.HashClusterMember) [][]models.DuplicatedMedia {
+	setList := [][]models.DuplicatedMedia{}
+	for _, cluster := range clusters {
+		set := []models.DuplicatedMedia{}
+		for _, member := range cluster {
+			set = append(set, HashClusterMemberToDuplicatedMedia(member))
+		}
+		setList = append(setList, set)
+	}
+	return setList
+}
+
+func SimilarMediaListToSimilarMediaList(similarMediaList []datasource.SimilarMedia) []models.SimilarMedia {
+	list := []models.SimilarMedia{}
+	for _, m := range similarMediaList {
+		s := models.SimilarMedia{
+			MediaData: MediaRecordToMediaData(m.MediaRecord),
+			Distance:  m.Distance,
+		}
+		if m.ContentLength.Valid {
+			s.ContentLength = uint64(m.ContentLength.Int64)
+		}
+		list = append(list, s)
+	}
+	return list
+}
@@ -0,0 +1,11 @@
+package models
+
+import "mediadata"
+
+type DuplicatedMedia struct {
+	mediadata.MediaData
+	ContentLength  uint64 `json:"contentLength"`
+	Distance       int    `json:"distance"`
+	Transform      string `json:"transform,omitempty"`
+	Representative bool   `json:"representative"`
+}
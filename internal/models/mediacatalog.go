@@ -1,15 +1,23 @@
 package models
 
 type MediaCatalog struct {
-	Id             string `json:"id"`
-	ParentUrl      string `json:"parentUrl"`
-	Type           string `json:"type"`
-	Url            string `json:"url"`
-	Timestamp      uint64 `json:"timestamp"`
-	HasCache       bool   `json:"hasCache"`
-	ContentLength  uint64 `json:"contentLength"`
-	DurationMillis uint   `json:"durationMillis,omitempty"`
-	VideoUrl       string `json:"videoUrl,omitempty"`
-	MediaPath      string `json:"mediaPath,omitempty"`
-	ThumbPath      string `json:"thumbPath,omitempty"`
+	Id             string  `json:"id"`
+	ParentUrl      string  `json:"parentUrl"`
+	Type           string  `json:"type"`
+	Url            string  `json:"url"`
+	Timestamp      uint64  `json:"timestamp"`
+	HasCache       bool    `json:"hasCache"`
+	ContentLength  uint64  `json:"contentLength"`
+	DurationMillis uint    `json:"durationMillis,omitempty"`
+	VideoUrl       string  `json:"videoUrl,omitempty"`
+	Width          uint    `json:"width,omitempty"`
+	Height         uint    `json:"height,omitempty"`
+	Codec          string  `json:"codec,omitempty"`
+	Bitrate        uint64  `json:"bitrate,omitempty"`
+	FrameRate      float64 `json:"frameRate,omitempty"`
+	MediaPath      string  `json:"mediaPath,omitempty"`
+	FilePath       string  `json:"filePath,omitempty"`
+	ThumbPath      string  `json:"thumbPath,omitempty"`
+	PreviewPath    string  `json:"previewPath,omitempty"`
+	SpritePath     string  `json:"spritePath,omitempty"`
 }
@@ -0,0 +1,9 @@
+package models
+
+import "mediadata"
+
+type SimilarMedia struct {
+	mediadata.MediaData
+	ContentLength uint64 `json:"contentLength"`
+	Distance      int    `json:"distance"`
+}
@@ -0,0 +1,124 @@
+package blobstore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+var ErrInvalidKey = errors.New("invalid blob key")
+
+var keyPattern = regexp.MustCompile(`^[0-9a-f]{64}(\.[0-9a-z]+)?$`)
+
+type BlobStore struct {
+	root string
+}
+
+func New(root string) *BlobStore {
+	return &BlobStore{root: root}
+}
+
+func (s *BlobStore) Root() string {
+	return s.root
+}
+
+func Key(data []byte, ext string) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]) + ext
+}
+
+func (s *BlobStore) Path(key string) (string, error) {
+	if !keyPattern.MatchString(key) {
+		return "", fmt.Errorf("%w: %s", ErrInvalidKey, key)
+	}
+	return filepath.Join(s.root, key[0:2], key[2:4], key), nil
+}
+
+func (s *BlobStore) Has(key string) bool {
+	p, err := s.Path(key)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(p)
+	return err == nil
+}
+
+func (s *BlobStore) Put(data []byte, ext string) (key string, err error) {
+	key = Key(data, ext)
+	p, err := s.Path(key)
+	if err != nil {
+		return
+	}
+
+	if _, err = os.Stat(p); err == nil {
+		return
+	}
+
+	dir := filepath.Dir(p)
+	if err = os.MkdirAll(dir, os.ModePerm); err != nil {
+		return
+	}
+
+	temp, err := os.CreateTemp(dir, ".blob-*")
+	if err != nil {
+		return
+	}
+	defer os.Remove(temp.Name())
+
+	if _, err = temp.Write(data); err != nil {
+		temp.Close()
+		return
+	}
+	if err = temp.Close(); err != nil {
+		return
+	}
+	if err = os.Chmod(temp.Name(), 0666); err != nil {
+		return
+	}
+
+	err = os.Rename(temp.Name(), p)
+	return
+}
+
+func (s *BlobStore) Get(key string) (data []byte, err error) {
+	p, err := s.Path(key)
+	if err != nil {
+		return
+	}
+
+	return os.ReadFile(p)
+}
+
+func (s *BlobStore) Delete(key string) (err error) {
+	p, err := s.Path(key)
+	if err != nil {
+		return
+	}
+
+	err = os.Remove(p)
+	if os.IsNotExist(err) {
+		err = nil
+	}
+	return
+}
+
+func (s *BlobStore) Keys() (keys []string, err error) {
+	err = filepath.WalkDir(s.root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p == s.root && os.IsNotExist(err) {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if d.Type().IsRegular() && keyPattern.MatchString(d.Name()) {
+			keys = append(keys, d.Name())
+		}
+		return nil
+	})
+	return
+}
@@ -0,0 +1,3 @@
+module blobstore
+
+go 1.21.4
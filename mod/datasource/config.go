@@ -0,0 +1,192 @@
+package datasource
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type ConnectConfig struct {
+	Driver   string
+	Url      string
+	User     string
+	Password string
+	Host     string
+	Port     uint16
+	Dbname   string
+
+	SSLMode        string
+	SSLRootCert    string
+	ConnectTimeout int
+
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime int
+}
+
+var sslModes = []string{"disable", "allow", "prefer", "require", "verify-ca", "verify-full"}
+
+func (config ConnectConfig) Validate() error {
+	switch config.Driver {
+	case "", DriverPostgres, DriverSQLite, DriverMemory:
+	default:
+		return fmt.Errorf("unsupported driver: %s", config.Driver)
+	}
+
+	if config.ConnectTimeout < 0 {
+		return fmt.Errorf("invalid ConnectTimeout: %d", config.ConnectTimeout)
+	}
+	if config.MaxOpenConns < 0 {
+		return fmt.Errorf("invalid MaxOpenConns: %d", config.MaxOpenConns)
+	}
+	if config.MaxIdleConns < 0 {
+		return fmt.Errorf("invalid MaxIdleConns: %d", config.MaxIdleConns)
+	}
+	if config.ConnMaxLifetime < 0 {
+		return fmt.Errorf("invalid ConnMaxLifetime: %d", config.ConnMaxLifetime)
+	}
+
+	switch config.Driver {
+	case DriverMemory:
+		return nil
+	case DriverSQLite:
+		if config.Dbname == "" {
+			return fmt.Errorf("Dbname (database file) is required for driver: %s", config.Driver)
+		}
+		return nil
+	}
+
+	if config.Url != "" {
+		u, err := url.Parse(config.Url)
+		if err != nil {
+			return fmt.Errorf("invalid database url: %w", err)
+		}
+		if u.Scheme != "postgres" && u.Scheme != "postgresql" {
+			return fmt.Errorf("unsupported database url scheme: %s", u.Scheme)
+		}
+		if config.databaseName() == "" {
+			return fmt.Errorf("database name is required in url or Dbname")
+		}
+		query := config.urlQuery(u)
+		return validateSSL(query.Get("sslmode"), query.Get("sslrootcert"))
+	}
+
+	if config.User == "" {
+		return fmt.Errorf("User is required")
+	}
+	if config.Dbname == "" {
+		return fmt.Errorf("Dbname is required")
+	}
+
+	return validateSSL(config.SSLMode, config.SSLRootCert)
+}
+
+func validateSSL(sslMode string, sslRootCert string) error {
+	if sslMode != "" && !contains(sslModes, sslMode) {
+		return fmt.Errorf("invalid SSLMode: %s (expected one of %s)", sslMode, strings.Join(sslModes, ", "))
+	}
+
+	if sslRootCert != "" {
+		if _, err := os.Stat(sslRootCert); err != nil {
+			return fmt.Errorf("invalid SSLRootCert: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (config ConnectConfig) databaseName() string {
+	if config.Url != "" {
+		if u, err := url.Parse(config.Url); err == nil && strings.TrimPrefix(u.Path, "/") != "" {
+			return strings.TrimPrefix(u.Path, "/")
+		}
+	}
+	return config.Dbname
+}
+
+// urlQuery adds SSLMode, SSLRootCert and ConnectTimeout to the query of a
+// connection URL. Parameters already in the URL win.
+func (config ConnectConfig) urlQuery(u *url.URL) url.Values {
+	query := u.Query()
+	params := [][2]string{
+		{"sslmode", config.SSLMode},
+		{"sslrootcert", config.SSLRootCert},
+	}
+	if config.ConnectTimeout > 0 {
+		params = append(params, [2]string{"connect_timeout", strconv.Itoa(config.ConnectTimeout)})
+	}
+	for _, param := range params {
+		if param[1] != "" && !query.Has(param[0]) {
+			query.Set(param[0], param[1])
+		}
+	}
+	return query
+}
+
+func (config ConnectConfig) postgresDSN(dbname string) string {
+	if config.Url != "" {
+		u, err := url.Parse(config.Url)
+		if err != nil {
+			return config.Url
+		}
+		u.Path = "/" + dbname
+		u.RawPath = ""
+		u.RawQuery = config.urlQuery(u).Encode()
+		return u.String()
+	}
+
+	sslMode := config.SSLMode
+	if sslMode == "" {
+		sslMode = "disable"
+	}
+
+	params := [][2]string{
+		{"user", config.User},
+		{"password", config.Password},
+		{"host", config.Host},
+		{"dbname", dbname},
+		{"sslmode", sslMode},
+		{"sslrootcert", config.SSLRootCert},
+	}
+	if config.Port != 0 {
+		params = append(params, [2]string{"port", strconv.Itoa(int(config.Port))})
+	}
+	if config.ConnectTimeout > 0 {
+		params = append(params, [2]string{"connect_timeout", strconv.Itoa(config.ConnectTimeout)})
+	}
+
+	dsn := []string{}
+	for _, param := range params {
+		if param[1] == "" {
+			continue
+		}
+		dsn = append(dsn, param[0]+"="+quoteDSNValue(param[1]))
+	}
+
+	return strings.Join(dsn, " ")
+}
+
+func (config ConnectConfig) connMaxLifetime() time.Duration {
+	return time.Duration(config.ConnMaxLifetime) * time.Second
+}
+
+func quoteDSNValue(value string) string {
+	if value != "" && !strings.ContainsAny(value, ` '\`) {
+		return value
+	}
+
+	replacer := strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+	return "'" + replacer.Replace(value) + "'"
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
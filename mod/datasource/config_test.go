@@ -0,0 +1,36 @@
+package datasource
+
+import "testing"
+
+func TestPostgresDSNFromUrl(t *testing.T) {
+	tests := []struct {
+		config ConnectConfig
+		want   string
+	}{
+		{
+			ConnectConfig{Url: "postgres://u:p@db/twx"},
+			"postgres://u:p@db/twx",
+		},
+		{
+			ConnectConfig{Url: "postgres://u:p@db/twx", SSLMode: "require", SSLRootCert: "/etc/ca.pem", ConnectTimeout: 5},
+			"postgres://u:p@db/twx?connect_timeout=5&sslmode=require&sslrootcert=%2Fetc%2Fca.pem",
+		},
+		{
+			ConnectConfig{Url: "postgres://u:p@db/twx?sslmode=disable", SSLMode: "require", ConnectTimeout: 5},
+			"postgres://u:p@db/twx?connect_timeout=5&sslmode=disable",
+		},
+	}
+
+	for _, test := range tests {
+		if got := test.config.postgresDSN("twx"); got != test.want {
+			t.Errorf("%+v: got %s, want %s", test.config, got, test.want)
+		}
+	}
+}
+
+func TestValidateUrlChecksSSLMode(t *testing.T) {
+	config := ConnectConfig{Driver: DriverPostgres, Url: "postgres://u:p@db/twx", SSLMode: "bogus"}
+	if err := config.Validate(); err == nil {
+		t.Error("invalid SSLMode was accepted with a url")
+	}
+}
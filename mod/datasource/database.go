@@ -7,69 +7,110 @@ import (
 	"os"
 	"strconv"
 	"strings"
-
-	_ "github.com/lib/pq"
+	"sync"
 )
 
-type ConnectConfig struct {
-	User     string
-	Password string
-	Host     string
-	Port     uint16
-	Dbname   string
-}
-
 type Database struct {
 	ConnectConfig
 
-	db *sql.DB
+	db      *sql.DB
+	dialect dialect
+
+	hashIndexMu         sync.Mutex
+	hashIndex           *HashIndex
+	hashIndexGeneration int64
 }
 
-func Connect(config ConnectConfig) (conn *Database, err error) {
-	conn = &Database{config, nil}
-	conn.db, err = sql.Open("postgres", "user="+conn.User+" dbname="+conn.Dbname+" sslmode=disable")
+func Open(config ConnectConfig) (conn *Database, err error) {
+	if err = config.Validate(); err != nil {
+		return
+	}
+
+	d, err := dialectFor(config.Driver)
 	if err != nil {
 		return
 	}
 
-	sql := `CREATE TABLE IF NOT EXISTS media(
-				media_id        TEXT PRIMARY KEY,
-				parent_url      TEXT NOT NULL,
-				type            TEXT NOT NULL,
-				url             TEXT NOT NULL,
-				timestamp       NUMERIC NOT NULL DEFAULT (EXTRACT(epoch FROM now()) * 1000::numeric)::bigint::numeric,
-				duration_millis NUMERIC,
-				video_url       TEXT,
+	db, err := sql.Open(d.driverName(), d.dataSourceName(config))
+	if err != nil {
+		return
+	}
+
+	if config.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(config.MaxOpenConns)
+	}
+	if config.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(config.MaxIdleConns)
+	}
+	if config.ConnMaxLifetime > 0 {
+		db.SetConnMaxLifetime(config.connMaxLifetime())
+	}
+
+	conn = &Database{ConnectConfig: config, db: db, dialect: d}
+	return
+}
 
-				content_length  BIGINT,
-				content_hash    BIGINT,
-				cache_path      TEXT,
-				thumbnail       BYTEA,
+func Connect(config ConnectConfig) (conn *Database, err error) {
+	conn, err = Open(config)
+	if err != nil {
+		return
+	}
 
-				removed         BOOLEAN NOT NULL DEFAULT FALSE,
-				created_at      TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
-				updated_at      TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
-			)`
-	_, err = conn.db.Query(sql)
+	err = conn.CheckSchema()
+	if err != nil {
+		conn.db.Close()
+		conn = nil
+	}
 
 	return
 }
 
+func OpenStore(config ConnectConfig) (Store, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	if config.Driver == DriverMemory {
+		return NewMemoryStore(), nil
+	}
+
+	conn, err := Connect(config)
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
 func Create(config ConnectConfig) (err error) {
-	db, err := sql.Open("postgres", "user="+config.User+" dbname=postgres")
+	if config.Driver == DriverSQLite {
+		conn, err := Open(config)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		_, err = conn.Migrate()
+		return err
+	}
+
+	if err = config.Validate(); err != nil {
+		return
+	}
+
+	db, err := sql.Open("postgres", config.postgresDSN("postgres"))
 	if err != nil {
 		return
 	}
 	defer db.Close()
 
-	_, err = db.Query("CREATE DATABASE " + config.Dbname + " TEMPLATE 'template0' ENCODING 'UTF-8' LC_COLLATE 'C.UTF-8' LC_CTYPE 'C.UTF-8'")
+	_, err = db.Exec("CREATE DATABASE " + config.databaseName() + " TEMPLATE 'template0' ENCODING 'UTF-8' LC_COLLATE 'C.UTF-8' LC_CTYPE 'C.UTF-8'")
 
 	return
 }
 
 func (conn *Database) Close() {
 	conn.db.Close()
-	log.Println("Close database: " + conn.Dbname)
+	log.Println("Close database: " + conn.databaseName())
 }
 
 func (conn *Database) UpsertMedia(columns []string, valueTable [][]any) (err error) {
@@ -123,11 +164,19 @@ func (conn *Database) GetMedia() (mediaRecordList []MediaRecord, err error) {
 				video_url,
 				content_length,
 				cache_path,
-				CASE WHEN thumbnail IS NOT NULL THEN true ELSE false END AS thumbnail
+				mime_type,
+				width,
+				height,
+				codec,
+				bitrate,
+				frame_rate,
+				CASE WHEN thumbnail IS NOT NULL OR thumbnail_key IS NOT NULL THEN true ELSE false END AS thumbnail,
+				CASE WHEN preview_key IS NOT NULL THEN true ELSE false END AS preview,
+				CASE WHEN sprite_key IS NOT NULL THEN true ELSE false END AS sprite
 			FROM
 				media
 			WHERE
-				removed='f'
+				removed=FALSE
 			ORDER BY
 				timestamp DESC
 			`
@@ -149,7 +198,15 @@ func (conn *Database) GetMedia() (mediaRecordList []MediaRecord, err error) {
 			&mediaRecord.VideoUrl,
 			&mediaRecord.ContentLength,
 			&mediaRecord.CachePath,
+			&mediaRecord.MimeType,
+			&mediaRecord.Width,
+			&mediaRecord.Height,
+			&mediaRecord.Codec,
+			&mediaRecord.Bitrate,
+			&mediaRecord.FrameRate,
 			&mediaRecord.HasThumbnail,
+			&mediaRecord.HasPreview,
+			&mediaRecord.HasSprite,
 		)
 		if err != nil {
 			return
@@ -173,7 +230,15 @@ func (conn *Database) GetMediaByID(id string) (mediaRecord MediaRecord, err erro
 				content_length,
 				content_hash,
 				cache_path,
-				CASE WHEN thumbnail IS NOT NULL THEN true ELSE false END AS thumbnail,
+				mime_type,
+				width,
+				height,
+				codec,
+				bitrate,
+				frame_rate,
+				CASE WHEN thumbnail IS NOT NULL OR thumbnail_key IS NOT NULL THEN true ELSE false END AS thumbnail,
+				CASE WHEN preview_key IS NOT NULL THEN true ELSE false END AS preview,
+				CASE WHEN sprite_key IS NOT NULL THEN true ELSE false END AS sprite,
 				removed
 			FROM
 				media
@@ -193,7 +258,15 @@ func (conn *Database) GetMediaByID(id string) (mediaRecord MediaRecord, err erro
 		&mediaRecord.ContentLength,
 		&mediaRecord.ContentHash,
 		&mediaRecord.CachePath,
+		&mediaRecord.MimeType,
+		&mediaRecord.Width,
+		&mediaRecord.Height,
+		&mediaRecord.Codec,
+		&mediaRecord.Bitrate,
+		&mediaRecord.FrameRate,
 		&mediaRecord.HasThumbnail,
+		&mediaRecord.HasPreview,
+		&mediaRecord.HasSprite,
 		&mediaRecord.Removed,
 	)
 
@@ -212,7 +285,15 @@ func (conn *Database) GetMediaByQuery(where string, args ...any) (mediaRecordLis
 				content_length,
 				content_hash,
 				cache_path,
-				CASE WHEN thumbnail IS NOT NULL THEN true ELSE false END AS thumbnail,
+				mime_type,
+				width,
+				height,
+				codec,
+				bitrate,
+				frame_rate,
+				CASE WHEN thumbnail IS NOT NULL OR thumbnail_key IS NOT NULL THEN true ELSE false END AS thumbnail,
+				CASE WHEN preview_key IS NOT NULL THEN true ELSE false END AS preview,
+				CASE WHEN sprite_key IS NOT NULL THEN true ELSE false END AS sprite,
 				removed
 			FROM
 				media
@@ -238,7 +319,15 @@ func (conn *Database) GetMediaByQuery(where string, args ...any) (mediaRecordLis
 			&mediaRecord.ContentLength,
 			&mediaRecord.ContentHash,
 			&mediaRecord.CachePath,
+			&mediaRecord.MimeType,
+			&mediaRecord.Width,
+			&mediaRecord.Height,
+			&mediaRecord.Codec,
+			&mediaRecord.Bitrate,
+			&mediaRecord.FrameRate,
 			&mediaRecord.HasThumbnail,
+			&mediaRecord.HasPreview,
+			&mediaRecord.HasSprite,
 			&mediaRecord.Removed,
 		)
 		if err != nil {
@@ -260,40 +349,143 @@ func (conn *Database) GetThumbnailByID(id string) (thumbnail []byte, err error)
 	return
 }
 
+func (conn *Database) GetThumbnailKeyByID(id string) (key string, err error) {
+	query := `SELECT thumbnail_key FROM media WHERE media_id=$1`
+	row := conn.db.QueryRow(query, id)
+
+	var thumbnailKey sql.NullString
+	err = row.Scan(&thumbnailKey)
+	key = thumbnailKey.String
+
+	return
+}
+
+func (conn *Database) GetThumbnailKeyBySize(id string, width uint) (key string, err error) {
+	query := `SELECT blob_key FROM thumbnails WHERE media_id=$1 AND width=$2`
+	row := conn.db.QueryRow(query, id, width)
+
+	err = row.Scan(&key)
+
+	return
+}
+
+func (conn *Database) SetThumbnailKeyBySize(mediaId string, width uint, key string) (err error) {
+	_, err = conn.db.Exec(`INSERT INTO thumbnails (media_id, width, blob_key)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (media_id, width)
+			DO UPDATE SET blob_key=EXCLUDED.blob_key, created_at=CURRENT_TIMESTAMP`, mediaId, width, key)
+	return
+}
+
+func (conn *Database) GetPreviewKeyByID(id string) (key string, err error) {
+	query := `SELECT preview_key FROM media WHERE media_id=$1`
+	row := conn.db.QueryRow(query, id)
+
+	var previewKey sql.NullString
+	err = row.Scan(&previewKey)
+	key = previewKey.String
+
+	return
+}
+
+func (conn *Database) GetSpriteKeyByID(id string) (key string, err error) {
+	query := `SELECT sprite_key FROM media WHERE media_id=$1`
+	row := conn.db.QueryRow(query, id)
+
+	var spriteKey sql.NullString
+	err = row.Scan(&spriteKey)
+	key = spriteKey.String
+
+	return
+}
+
+func (conn *Database) GetLegacyThumbnailMediaIds() (mediaIds []string, err error) {
+	rows, err := conn.db.Query(`SELECT media_id FROM media WHERE thumbnail IS NOT NULL`)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var mediaId string
+		if err = rows.Scan(&mediaId); err != nil {
+			return
+		}
+		mediaIds = append(mediaIds, mediaId)
+	}
+
+	return
+}
+
+func (conn *Database) GetBlobKeys() (keys []string, err error) {
+	rows, err := conn.db.Query(`SELECT thumbnail_key FROM media WHERE thumbnail_key IS NOT NULL
+			UNION SELECT preview_key FROM media WHERE preview_key IS NOT NULL
+			UNION SELECT sprite_key FROM media WHERE sprite_key IS NOT NULL
+			UNION SELECT blob_key FROM thumbnails`)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key string
+		if err = rows.Scan(&key); err != nil {
+			return
+		}
+		keys = append(keys, key)
+	}
+
+	return
+}
+
+func (conn *Database) SetMediaHash(mediaId string, algorithm string, transform string, hash string) (err error) {
+	_, err = conn.db.Exec(`INSERT INTO media_hashes (media_id, algorithm, transform, hash)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (media_id, algorithm, transform)
+			DO UPDATE SET hash=EXCLUDED.hash, created_at=CURRENT_TIMESTAMP`, mediaId, algorithm, transform, hash)
+	return
+}
+
 func (conn *Database) DeleteMediaAll() (err error) {
-	_, err = conn.db.Exec("UPDATE media SET removed='t', updated_at=CURRENT_TIMESTAMP WHERE removed='f'")
+	_, err = conn.db.Exec("UPDATE media SET removed=TRUE, updated_at=CURRENT_TIMESTAMP WHERE removed=FALSE")
 	return
 }
 
 func (conn *Database) DeleteMediaCached() (err error) {
-	_, err = conn.db.Exec("UPDATE media SET removed='t', updated_at=CURRENT_TIMESTAMP WHERE removed='f' AND content_length IS NOT NULL")
+	_, err = conn.db.Exec("UPDATE media SET removed=TRUE, updated_at=CURRENT_TIMESTAMP WHERE removed=FALSE AND content_length IS NOT NULL")
 	return
 }
 
 func (conn *Database) DeleteMedia(id string) (err error) {
-	_, err = conn.db.Exec("UPDATE media SET removed='t', updated_at=CURRENT_TIMESTAMP WHERE removed='f' AND media_id=$1", id)
+	_, err = conn.db.Exec("UPDATE media SET removed=TRUE, updated_at=CURRENT_TIMESTAMP WHERE removed=FALSE AND media_id=$1", id)
 	return
 }
 
 func (conn *Database) DeleteCacheFile(id string) (err error) {
-	_, err = conn.db.Exec("UPDATE media SET content_length=0, cache_path=NULL, removed='t', updated_at=CURRENT_TIMESTAMP WHERE media_id=$1", id)
-	return
+	_, err = conn.db.Exec("UPDATE media SET content_length=0, cache_path=NULL, removed=TRUE, updated_at=CURRENT_TIMESTAMP WHERE media_id=$1", id)
+	if err != nil {
+		return
+	}
+
+	return conn.hashesChanged(id, 0, 0)
 }
 
 func (conn *Database) GetNoCacheMedia() (mediaList []map[string]any, err error) {
 	query := `SELECT
 				media_id,
+				parent_url,
 				type,
 				url,
+				timestamp,
 				video_url
 			FROM
 				media
 			WHERE
-				removed='f' AND content_length IS NULL
+				removed=FALSE AND content_length IS NULL AND download_failed=FALSE AND download_attempts < $1
 			ORDER BY
 				timestamp DESC
 			`
-	rows, err := conn.db.Query(query)
+	rows, err := conn.db.Query(query, MaxDownloadAttempts)
 	if err != nil {
 		return
 	}
@@ -301,24 +493,30 @@ func (conn *Database) GetNoCacheMedia() (mediaList []map[string]any, err error)
 
 	for rows.Next() {
 		var mediaId string
+		var parentUrl string
 		var mediaType string
 		var url string
+		var timestamp uint64
 		var videoUrl sql.NullString
-		err = rows.Scan(&mediaId, &mediaType, &url, &videoUrl)
+		err = rows.Scan(&mediaId, &parentUrl, &mediaType, &url, &timestamp, &videoUrl)
 		if err != nil {
 			return
 		}
 		mediaList = append(mediaList, map[string]any{
-			"mediaId":  mediaId,
-			"type":     mediaType,
-			"url":      url,
-			"videoUrl": videoUrl,
+			"mediaId":   mediaId,
+			"parentUrl": parentUrl,
+			"type":      mediaType,
+			"url":       url,
+			"timestamp": timestamp,
+			"videoUrl":  videoUrl,
 		})
 	}
 
 	return
 }
 
+const MaxDownloadAttempts = 5
+
 type CachedVideoMedia struct {
 	Id   string
 	Path string
@@ -331,7 +529,7 @@ func (conn *Database) GetCachedVideoMedia() (cachedVideoMediaList []CachedVideoM
 			FROM
 				media
 			WHERE
-				type='video' AND removed='f' AND content_length IS NOT NULL
+				type='video' AND removed=FALSE AND content_length IS NOT NULL
 			ORDER BY
 				timestamp DESC
 			`
@@ -359,20 +557,104 @@ func (conn *Database) GetCachedVideoMedia() (cachedVideoMediaList []CachedVideoM
 	return
 }
 
+type AnimatedMedia struct {
+	Id             string
+	Type           string
+	Path           string
+	DurationMillis uint
+	NeedsPreview   bool
+	NeedsSprite    bool
+}
+
+func (conn *Database) GetMediaWithoutPreview() (animatedMediaList []AnimatedMedia, err error) {
+	query := `SELECT
+				media_id,
+				type,
+				cache_path,
+				duration_millis,
+				preview_key IS NULL AND preview_failed=FALSE,
+				sprite_key IS NULL AND sprite_failed=FALSE
+			FROM
+				media
+			WHERE
+				type IN ('video', 'animated_gif') AND removed=FALSE AND content_length > 0 AND cache_path IS NOT NULL
+				AND ((preview_key IS NULL AND preview_failed=FALSE) OR (sprite_key IS NULL AND sprite_failed=FALSE))
+			ORDER BY
+				timestamp DESC
+			`
+	rows, err := conn.db.Query(query)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var animatedMedia AnimatedMedia
+		var durationMillis sql.NullInt32
+		err = rows.Scan(&animatedMedia.Id, &animatedMedia.Type, &animatedMedia.Path, &durationMillis, &animatedMedia.NeedsPreview, &animatedMedia.NeedsSprite)
+		if err != nil {
+			return
+		}
+		if durationMillis.Valid && durationMillis.Int32 > 0 {
+			animatedMedia.DurationMillis = uint(durationMillis.Int32)
+		}
+		animatedMediaList = append(animatedMediaList, animatedMedia)
+	}
+
+	return
+}
+
+type CachedMedia struct {
+	Id   string
+	Type string
+	Path string
+}
+
+func (conn *Database) GetUnprobedMedia() (cachedMediaList []CachedMedia, err error) {
+	query := `SELECT
+				media_id,
+				type,
+				cache_path
+			FROM
+				media
+			WHERE
+				removed=FALSE AND content_length > 0 AND cache_path IS NOT NULL AND width IS NULL
+			ORDER BY
+				timestamp DESC
+			`
+	rows, err := conn.db.Query(query)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cachedMedia CachedMedia
+		err = rows.Scan(&cachedMedia.Id, &cachedMedia.Type, &cachedMedia.Path)
+		if err != nil {
+			return
+		}
+		cachedMediaList = append(cachedMediaList, cachedMedia)
+	}
+
+	return
+}
+
 type UnhashedMedia struct {
-	MediaId   string
-	Thumbnail []byte
+	MediaId      string
+	ThumbnailKey string
+	Thumbnail    []byte
 }
 
 func (conn *Database) GetUnhashedMedia() (unhashedMediaList []UnhashedMedia, err error) {
 	query := `SELECT
 				media_id,
-				type,
-				thumbnail
+				thumbnail_key,
+				CASE WHEN thumbnail_key IS NULL THEN thumbnail END
 			FROM
 				media
 			WHERE
-				content_length > 0 AND content_hash IS NULL AND thumbnail IS NOT NULL
+				content_length > 0 AND content_hash IS NULL AND (thumbnail IS NOT NULL OR thumbnail_key IS NOT NULL)
 			`
 	rows, err := conn.db.Query(query)
 	if err != nil {
@@ -382,16 +664,53 @@ func (conn *Database) GetUnhashedMedia() (unhashedMediaList []UnhashedMedia, err
 
 	for rows.Next() {
 		var mediaId string
-		var mediaType string
+		var thumbnailKey sql.NullString
+		var thumbnail []byte
+		err = rows.Scan(&mediaId, &thumbnailKey, &thumbnail)
+		if err != nil {
+			return
+		}
+
+		unhashedMediaList = append(unhashedMediaList, UnhashedMedia{
+			MediaId:      mediaId,
+			ThumbnailKey: thumbnailKey.String,
+			Thumbnail:    thumbnail,
+		})
+	}
+
+	return
+}
+
+func (conn *Database) GetMediaWithoutHash(algorithm string, transform string) (unhashedMediaList []UnhashedMedia, err error) {
+	query := `SELECT
+				media_id,
+				thumbnail_key,
+				CASE WHEN thumbnail_key IS NULL THEN thumbnail END
+			FROM
+				media
+			WHERE
+				content_length > 0 AND (thumbnail IS NOT NULL OR thumbnail_key IS NOT NULL)
+				AND NOT EXISTS (SELECT 1 FROM media_hashes WHERE media_hashes.media_id = media.media_id AND media_hashes.algorithm = $1 AND media_hashes.transform = $2)
+			`
+	rows, err := conn.db.Query(query, algorithm, transform)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var mediaId string
+		var thumbnailKey sql.NullString
 		var thumbnail []byte
-		err = rows.Scan(&mediaId, &mediaType, &thumbnail)
+		err = rows.Scan(&mediaId, &thumbnailKey, &thumbnail)
 		if err != nil {
 			return
 		}
 
 		unhashedMediaList = append(unhashedMediaList, UnhashedMedia{
-			MediaId:   mediaId,
-			Thumbnail: thumbnail,
+			MediaId:      mediaId,
+			ThumbnailKey: thumbnailKey.String,
+			Thumbnail:    thumbnail,
 		})
 	}
 
@@ -403,13 +722,23 @@ type DuplicatedHash struct {
 	Count       int
 }
 
-func (conn *Database) GetMediaDataSet(where string, args ...any) ([]MediaRecord, error) {
-	result, err := conn.GetMediaByQuery(where, args...)
-	if err != nil {
-		return nil, err
+func (conn *Database) GetMediaByCachePath(patterns []string) (mediaRecordList []MediaRecord, err error) {
+	if len(patterns) == 0 {
+		return
+	}
+
+	condition := []string{}
+	args := []any{}
+	for i, pattern := range patterns {
+		condition = append(condition, fmt.Sprintf(`cache_path LIKE $%d`, i+1))
+		args = append(args, fmt.Sprintf(`%%%s%%`, pattern))
 	}
 
-	return result, nil
+	return conn.GetMediaByQuery(strings.Join(condition, " OR "), args...)
+}
+
+func (conn *Database) GetMediaWithCache() (mediaRecordList []MediaRecord, err error) {
+	return conn.GetMediaByQuery("cache_path IS NOT NULL")
 }
 
 func GetSelfName() string {
@@ -418,16 +747,110 @@ func GetSelfName() string {
 }
 
 func (conn *Database) SetCacheData(mediaId string, contentLength uint64, contentHash uint64, cachePath string) (err error) {
-	_, err = conn.db.Exec("UPDATE media SET content_length=$2, content_hash=$3, cache_path=$4, updated_at=CURRENT_TIMESTAMP WHERE media_id=$1", mediaId, contentLength, int64(contentHash), cachePath)
+	_, err = conn.db.Exec("UPDATE media SET content_length=$2, content_hash=$3, cache_path=$4, last_error=NULL, updated_at=CURRENT_TIMESTAMP WHERE media_id=$1", mediaId, contentLength, int64(contentHash), cachePath)
+	if err != nil {
+		return
+	}
+
+	return conn.hashesChanged(mediaId, int64(contentLength), contentHash)
+}
+
+func (conn *Database) SetCachePaths(cachePaths map[string]string) (err error) {
+	tx, err := conn.db.Begin()
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+
+	for mediaId, cachePath := range cachePaths {
+		_, err = tx.Exec("UPDATE media SET cache_path=$2, updated_at=CURRENT_TIMESTAMP WHERE media_id=$1", mediaId, cachePath)
+		if err != nil {
+			return
+		}
+	}
+
+	err = tx.Commit()
 	return
 }
 
-func (conn *Database) SetThumbnail(mediaId string, thumbnail []byte) (err error) {
-	_, err = conn.db.Exec("UPDATE media SET thumbnail=$2, updated_at=CURRENT_TIMESTAMP WHERE media_id=$1", mediaId, thumbnail)
+func (conn *Database) SetMediaMetadata(mediaId string, width uint, height uint, durationMillis uint, codec string, bitrate uint64, frameRate float64) (err error) {
+	_, err = conn.db.Exec(`UPDATE media SET
+			width=$2,
+			height=$3,
+			duration_millis=CASE WHEN $4 > 0 THEN $4 ELSE duration_millis END,
+			codec=NULLIF($5, ''),
+			bitrate=$6,
+			frame_rate=$7,
+			updated_at=CURRENT_TIMESTAMP
+		WHERE media_id=$1`, mediaId, width, height, durationMillis, codec, int64(bitrate), frameRate)
 	return
 }
 
-func (conn *Database) SetContentHashData(mediaId string, contentHash uint64) (err error) {
-	_, err = conn.db.Exec("UPDATE media SET content_hash=$2, updated_at=CURRENT_TIMESTAMP WHERE media_id=$1", mediaId, contentHash)
+func (conn *Database) SetMimeType(mediaId string, mimeType string) (err error) {
+	_, err = conn.db.Exec("UPDATE media SET mime_type=$2, updated_at=CURRENT_TIMESTAMP WHERE media_id=$1", mediaId, mimeType)
+	return
+}
+
+func (conn *Database) RecordDownloadFailure(mediaId string, lastError string, permanent bool) (err error) {
+	_, err = conn.db.Exec("UPDATE media SET download_attempts=download_attempts+1, download_failed=(download_failed OR $2), last_error=$3, updated_at=CURRENT_TIMESTAMP WHERE media_id=$1", mediaId, permanent, lastError)
+	return
+}
+
+func (conn *Database) RequeueMedia(mediaId string) (err error) {
+	_, err = conn.db.Exec("UPDATE media SET content_length=NULL, content_hash=NULL, cache_path=NULL, download_attempts=0, download_failed=FALSE, last_error=NULL, preview_failed=FALSE, sprite_failed=FALSE, updated_at=CURRENT_TIMESTAMP WHERE media_id=$1", mediaId)
+	if err != nil {
+		return
+	}
+
+	_, err = conn.db.Exec("DELETE FROM media_hashes WHERE media_id=$1", mediaId)
+	if err != nil {
+		return
+	}
+
+	return conn.hashesChanged(mediaId, 0, 0)
+}
+
+func (conn *Database) SetThumbnailKey(mediaId string, key string) (err error) {
+	_, err = conn.db.Exec("UPDATE media SET thumbnail_key=$2, thumbnail=NULL, updated_at=CURRENT_TIMESTAMP WHERE media_id=$1", mediaId, key)
+	return
+}
+
+func (conn *Database) SetPreviewKey(mediaId string, key string) (err error) {
+	_, err = conn.db.Exec("UPDATE media SET preview_key=$2, updated_at=CURRENT_TIMESTAMP WHERE media_id=$1", mediaId, key)
+	return
+}
+
+func (conn *Database) SetSpriteKey(mediaId string, key string) (err error) {
+	_, err = conn.db.Exec("UPDATE media SET sprite_key=$2, updated_at=CURRENT_TIMESTAMP WHERE media_id=$1", mediaId, key)
+	return
+}
+
+func (conn *Database) SetPreviewFailed(mediaId string) (err error) {
+	_, err = conn.db.Exec("UPDATE media SET preview_failed=TRUE, updated_at=CURRENT_TIMESTAMP WHERE media_id=$1", mediaId)
 	return
 }
+
+func (conn *Database) SetSpriteFailed(mediaId string) (err error) {
+	_, err = conn.db.Exec("UPDATE media SET sprite_failed=TRUE, updated_at=CURRENT_TIMESTAMP WHERE media_id=$1", mediaId)
+	return
+}
+
+func (conn *Database) SetContentHashData(mediaId string, contentHash uint64) (err error) {
+	_, err = conn.db.Exec("UPDATE media SET content_hash=$2, updated_at=CURRENT_TIMESTAMP WHERE media_id=$1", mediaId, int64(contentHash))
+	if err != nil {
+		return
+	}
+
+	var contentLength sql.NullInt64
+	if conn.hashIndexLoaded() {
+		err = conn.db.QueryRow("SELECT content_length FROM media WHERE media_id=$1", mediaId).Scan(&contentLength)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return
+		}
+	}
+
+	return conn.hashesChanged(mediaId, contentLength.Int64, contentHash)
+}
@@ -0,0 +1,114 @@
+package datasource
+
+import (
+	"fmt"
+	"net/url"
+
+	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
+)
+
+const (
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite"
+	DriverMemory   = "memory"
+)
+
+type dialect interface {
+	driverName() string
+	dataSourceName(config ConnectConfig) string
+	formatDate(column string) string
+	tableExists() string
+	createMediaTable() string
+}
+
+func dialectFor(driver string) (dialect, error) {
+	switch driver {
+	case "", DriverPostgres:
+		return postgresDialect{}, nil
+	case DriverSQLite:
+		return sqliteDialect{}, nil
+	}
+	return nil, fmt.Errorf("unsupported driver: %s", driver)
+}
+
+type postgresDialect struct{}
+
+func (postgresDialect) driverName() string {
+	return "postgres"
+}
+
+func (postgresDialect) dataSourceName(config ConnectConfig) string {
+	return config.postgresDSN(config.databaseName())
+}
+
+func (postgresDialect) formatDate(column string) string {
+	return "TO_CHAR(TO_TIMESTAMP(" + column + " / 1000), 'YYYY-MM-DD')"
+}
+
+func (postgresDialect) tableExists() string {
+	return `SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = $1`
+}
+
+func (postgresDialect) createMediaTable() string {
+	return `CREATE TABLE IF NOT EXISTS media(
+				media_id        TEXT PRIMARY KEY,
+				parent_url      TEXT NOT NULL,
+				type            TEXT NOT NULL,
+				url             TEXT NOT NULL,
+				timestamp       NUMERIC NOT NULL DEFAULT (EXTRACT(epoch FROM now()) * 1000::numeric)::bigint::numeric,
+				duration_millis NUMERIC,
+				video_url       TEXT,
+
+				content_length  BIGINT,
+				content_hash    BIGINT,
+				cache_path      TEXT,
+				thumbnail       BYTEA,
+
+				removed         BOOLEAN NOT NULL DEFAULT FALSE,
+				created_at      TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				updated_at      TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+			)`
+}
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) driverName() string {
+	return "sqlite"
+}
+
+func (sqliteDialect) dataSourceName(config ConnectConfig) string {
+	params := url.Values{}
+	params.Add("_pragma", "busy_timeout(5000)")
+	params.Add("_pragma", "journal_mode(WAL)")
+	return "file:" + config.Dbname + "?" + params.Encode()
+}
+
+func (sqliteDialect) formatDate(column string) string {
+	return "strftime('%Y-%m-%d', " + column + " / 1000, 'unixepoch', 'localtime')"
+}
+
+func (sqliteDialect) tableExists() string {
+	return `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = $1`
+}
+
+func (sqliteDialect) createMediaTable() string {
+	return `CREATE TABLE IF NOT EXISTS media(
+				media_id        TEXT PRIMARY KEY,
+				parent_url      TEXT NOT NULL,
+				type            TEXT NOT NULL,
+				url             TEXT NOT NULL,
+				timestamp       INTEGER NOT NULL DEFAULT (CAST(strftime('%s', 'now') AS INTEGER) * 1000),
+				duration_millis INTEGER,
+				video_url       TEXT,
+
+				content_length  INTEGER,
+				content_hash    INTEGER,
+				cache_path      TEXT,
+				thumbnail       BLOB,
+
+				removed         BOOLEAN NOT NULL DEFAULT FALSE,
+				created_at      TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				updated_at      TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+			)`
+}
@@ -1,7 +1,9 @@
 package datasource
 
+import "fmt"
+
 func (conn *Database) GetCatalog(date string) (mediaRecordList []MediaRecord, err error) {
-	query := `SELECT
+	query := fmt.Sprintf(`SELECT
 				media_id,
 				parent_url,
 				type,
@@ -11,14 +13,22 @@ func (conn *Database) GetCatalog(date string) (mediaRecordList []MediaRecord, er
 				video_url,
 				content_length,
 				cache_path,
-				CASE WHEN thumbnail IS NOT NULL THEN true ELSE false END AS thumbnail
+				mime_type,
+				width,
+				height,
+				codec,
+				bitrate,
+				frame_rate,
+				CASE WHEN thumbnail IS NOT NULL OR thumbnail_key IS NOT NULL THEN true ELSE false END AS thumbnail,
+				CASE WHEN preview_key IS NOT NULL THEN true ELSE false END AS preview,
+				CASE WHEN sprite_key IS NOT NULL THEN true ELSE false END AS sprite
 			FROM
 				media
 			WHERE
-				content_length > 0 AND cache_path IS NOT NULL AND TO_CHAR(TO_TIMESTAMP(timestamp / 1000), 'YYYY-MM-DD') = $1
+				content_length > 0 AND cache_path IS NOT NULL AND %[1]s = $1
 			ORDER BY
 				timestamp DESC
-			`
+			`, conn.dialect.formatDate("timestamp"))
 	rows, err := conn.db.Query(query, date)
 	if err != nil {
 		return
@@ -37,7 +47,15 @@ func (conn *Database) GetCatalog(date string) (mediaRecordList []MediaRecord, er
 			&mediaRecord.VideoUrl,
 			&mediaRecord.ContentLength,
 			&mediaRecord.CachePath,
+			&mediaRecord.MimeType,
+			&mediaRecord.Width,
+			&mediaRecord.Height,
+			&mediaRecord.Codec,
+			&mediaRecord.Bitrate,
+			&mediaRecord.FrameRate,
 			&mediaRecord.HasThumbnail,
+			&mediaRecord.HasPreview,
+			&mediaRecord.HasSprite,
 		)
 		if err != nil {
 			return
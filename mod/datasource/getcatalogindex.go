@@ -1,10 +1,12 @@
 package datasource
 
+import "fmt"
+
 func (conn *Database) GetCatalogIndex(minSize uint64) (dates []string, err error) {
-	query := `SELECT DISTINCT TO_CHAR(TO_TIMESTAMP(timestamp / 1000), 'YYYY-MM-DD')
+	query := fmt.Sprintf(`SELECT DISTINCT %[1]s
 			FROM media
 			WHERE content_length > $1 AND cache_path IS NOT NULL
-			ORDER BY TO_CHAR(TO_TIMESTAMP(timestamp / 1000), 'YYYY-MM-DD') DESC`
+			ORDER BY %[1]s DESC`, conn.dialect.formatDate("timestamp"))
 	rows, err := conn.db.Query(query, minSize)
 	if err != nil {
 		return
@@ -2,71 +2,435 @@ package datasource
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 
+	"diffhash"
 	_ "github.com/lib/pq"
 )
 
-type Media struct {
-	MediaID     string
-	ContentHash uint64
+const ContentHashAlgorithm = "dhash"
+
+const untransformed = ""
+
+type HashClusterOptions struct {
+	MaxDistance     int
+	MinSize         int
+	Type            string
+	Algorithms      []string
+	MatchTransforms bool
+}
+
+func NewHashClusterOptions() HashClusterOptions {
+	return HashClusterOptions{MaxDistance: DefaultDuplicateDistance, MinSize: 2}
+}
+
+func (options HashClusterOptions) minSize() int {
+	if options.MinSize < 2 {
+		return 2
+	}
+	return options.MinSize
+}
+
+func (options HashClusterOptions) maxDistance() int {
+	return min(options.MaxDistance, MaxDuplicateDistance)
+}
+
+func (options HashClusterOptions) algorithms() []string {
+	if len(options.Algorithms) == 0 {
+		return []string{ContentHashAlgorithm}
+	}
+	return options.Algorithms
+}
+
+type HashClusterMember struct {
+	MediaRecord
+	Distance       int
+	Transform      string
+	Representative bool
+}
+
+func isBetterRepresentative(a, b MediaRecord) bool {
+	if a.ContentLength.Int64 != b.ContentLength.Int64 {
+		return a.ContentLength.Int64 > b.ContentLength.Int64
+	}
+	if a.Timestamp != b.Timestamp {
+		return a.Timestamp < b.Timestamp
+	}
+	return a.MediaId < b.MediaId
+}
+
+func contentHashDistance(a, b MediaRecord) (int, string) {
+	return hammingDistance([]uint64{uint64(a.ContentHash.Int64)}, []uint64{uint64(b.ContentHash.Int64)}), untransformed
 }
 
-func hammingDistance(a, b uint64, max int) bool {
-	x := a ^ b
-	count := 0
-	for x != 0 {
-		x &= x - 1
-		count++
-		if count > max {
-			break
+// rankHashCluster orders the members of a cluster. Clusters are linked by
+// single linkage, so each member is within the maximum distance of some other
+// member, but its distance to the representative may be larger.
+func rankHashCluster(mediaRecordList []MediaRecord, distance func(a, b MediaRecord) (int, string)) (members []HashClusterMember) {
+	if len(mediaRecordList) == 0 {
+		return
+	}
+
+	representative := mediaRecordList[0]
+	for _, mediaRecord := range mediaRecordList[1:] {
+		if isBetterRepresentative(mediaRecord, representative) {
+			representative = mediaRecord
+		}
+	}
+
+	for _, mediaRecord := range mediaRecordList {
+		d, transform := distance(mediaRecord, representative)
+		members = append(members, HashClusterMember{
+			MediaRecord:    mediaRecord,
+			Distance:       d,
+			Transform:      transform,
+			Representative: mediaRecord.MediaId == representative.MediaId,
+		})
+	}
+
+	sort.Slice(members, func(i, j int) bool {
+		if members[i].Representative != members[j].Representative {
+			return members[i].Representative
 		}
+		if members[i].Distance != members[j].Distance {
+			return members[i].Distance < members[j].Distance
+		}
+		return members[i].MediaId < members[j].MediaId
+	})
+	return
+}
+
+func totalDistance(members []HashClusterMember) (total int) {
+	for _, member := range members {
+		total += member.Distance
 	}
-	return count <= max
+	return
+}
+
+func sortHashClusters(clusters [][]HashClusterMember) {
+	sort.Slice(clusters, func(i, j int) bool {
+		if len(clusters[i]) != len(clusters[j]) {
+			return len(clusters[i]) > len(clusters[j])
+		}
+		if ti, tj := totalDistance(clusters[i]), totalDistance(clusters[j]); ti != tj {
+			return ti < tj
+		}
+		return clusters[i][0].MediaId < clusters[j][0].MediaId
+	})
 }
 
-func clusterMedia(mediaList []Media) map[string][]string {
+func hasAllHashes(hashSets []map[string][]uint64, mediaId string) bool {
+	for _, hashSet := range hashSets {
+		if _, ok := hashSet[mediaId]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// collectHashSets arranges the hashes of each algorithm by transform:
+// hashSets[transform][i] holds the hashes of algorithms[i].
+func collectHashSets(algorithms []string, load func(algorithm string) (map[string]map[string][]uint64, error)) (hashSets map[string][]map[string][]uint64, err error) {
+	hashSets = map[string][]map[string][]uint64{}
+	for i, algorithm := range algorithms {
+		var byTransform map[string]map[string][]uint64
+		byTransform, err = load(algorithm)
+		if err != nil {
+			return
+		}
+		for transform, hashSet := range byTransform {
+			if hashSets[transform] == nil {
+				hashSets[transform] = make([]map[string][]uint64, len(algorithms))
+			}
+			hashSets[transform][i] = hashSet
+		}
+	}
+	return
+}
+
+// clusterHashSets links a with b when some transform of b is close to a in
+// every hash set. Distances scale with the hash length: maxDistance is per 64
+// bits. The distance to the representative reports the closest transform.
+func clusterHashSets(hashSets map[string][]map[string][]uint64, maxDistance int, include func(mediaId string) bool) (clusters map[string][]string, distance func(a, b MediaRecord) (int, string)) {
+	original := hashSets[untransformed]
+	transforms := []string{}
+	for transform := range hashSets {
+		transforms = append(transforms, transform)
+	}
+	sort.Strings(transforms)
+
+	distance = func(a, b MediaRecord) (int, string) {
+		best, bestTransform := -1, untransformed
+		target, ok := original[0][b.MediaId]
+		for _, transform := range transforms {
+			hash, found := hashSets[transform][0][a.MediaId]
+			if !ok || !found || len(hash) != len(target) {
+				continue
+			}
+			if d := hammingDistance(target, hash); best < 0 || d < best {
+				best, bestTransform = d, transform
+			}
+		}
+		return max(best, 0), bestTransform
+	}
+
+	clusters = map[string][]string{}
+	if len(original) == 0 {
+		return
+	}
+
+	words := 0
+	for _, hash := range original[0] {
+		words = len(hash)
+		break
+	}
+
+	ids := []string{}
+	for id := range original[0] {
+		if (include == nil || include(id)) && hasAllHashes(original, id) {
+			ids = append(ids, id)
+		}
+	}
+
 	uf := NewUnionFind()
-	n := len(mediaList)
-	for i := 0; i < n; i++ {
-		for j := i + 1; j < n; j++ {
-			if hammingDistance(mediaList[i].ContentHash, mediaList[j].ContentHash, 8) {
-				uf.Union(mediaList[i].MediaID, mediaList[j].MediaID)
+	for _, transform := range transforms {
+		transformed := hashSets[transform]
+		index := NewHashIndex(words)
+		for _, id := range ids {
+			if hasAllHashes(transformed, id) {
+				index.Add(id, transformed[0][id])
+			}
+		}
+
+		for _, id := range ids {
+			for _, match := range index.Search(original[0][id], maxDistance*words) {
+				if match.MediaId != id && isCloseInAll(original, transformed, id, match.MediaId, maxDistance) {
+					uf.Union(id, match.MediaId)
+				}
 			}
 		}
 	}
 
-	clusters := make(map[string][]string)
-	for _, m := range mediaList {
-		root := uf.Find(m.MediaID)
-		clusters[root] = append(clusters[root], m.MediaID)
+	for _, id := range ids {
+		root := uf.Find(id)
+		clusters[root] = append(clusters[root], id)
 	}
-	return clusters
+	return
 }
 
-func (conn *Database) GetHashCluster() (duplicatedMediaList [][]MediaRecord, err error) {
+func isCloseInAll(original, transformed []map[string][]uint64, a, b string, maxDistance int) bool {
+	for i := 1; i < len(original); i++ {
+		if len(original[i][a]) != len(transformed[i][b]) {
+			return false
+		}
+		if hammingDistance(original[i][a], transformed[i][b]) > maxDistance*len(original[i][a]) {
+			return false
+		}
+	}
+	return true
+}
+
+// hash_generation counts hash writes. The -caching, -calc-diffhash and
+// -verify-cache processes write hashes through their own connections, so a
+// long-lived -listen process compares it on every load and rebuilds the
+// index when another process changed the rows.
+func (conn *Database) getHashGeneration() (generation int64, err error) {
+	err = conn.db.QueryRow(`SELECT generation FROM hash_generation`).Scan(&generation)
+	return
+}
+
+func (conn *Database) loadHashIndex() (index *HashIndex, err error) {
+	generation, err := conn.getHashGeneration()
+	if err != nil {
+		return
+	}
+
+	conn.hashIndexMu.Lock()
+	defer conn.hashIndexMu.Unlock()
+
+	if conn.hashIndex != nil && conn.hashIndexGeneration == generation {
+		return conn.hashIndex, nil
+	}
+
 	rows, err := conn.db.Query(`SELECT media_id, content_hash FROM media WHERE content_length > 0 AND content_hash != 0`)
 	if err != nil {
 		return
 	}
 	defer rows.Close()
 
-	var mediaList []Media
+	index = NewHashIndex(1)
 	for rows.Next() {
-		var m Media
+		var mediaId string
 		var sinedHash int64
-		err = rows.Scan(&m.MediaID, &sinedHash)
+		err = rows.Scan(&mediaId, &sinedHash)
+		if err != nil {
+			return nil, err
+		}
+		index.Add(mediaId, []uint64{uint64(sinedHash)})
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	conn.hashIndex = index
+	conn.hashIndexGeneration = generation
+	return
+}
+
+func (conn *Database) hashIndexLoaded() bool {
+	conn.hashIndexMu.Lock()
+	defer conn.hashIndexMu.Unlock()
+	return conn.hashIndex != nil
+}
+
+// hashesChanged bumps the hash generation after a hash write and applies the
+// write to a loaded index. The index only takes the new generation when no
+// other write was counted since it was loaded; otherwise the next load
+// rebuilds it.
+func (conn *Database) hashesChanged(mediaId string, contentLength int64, contentHash uint64) (err error) {
+	var generation int64
+	err = conn.db.QueryRow(`UPDATE hash_generation SET generation = generation + 1 RETURNING generation`).Scan(&generation)
+	if err != nil {
+		return
+	}
+
+	conn.hashIndexMu.Lock()
+	defer conn.hashIndexMu.Unlock()
+
+	if conn.hashIndex == nil {
+		return
+	}
+	if contentLength > 0 && contentHash != 0 {
+		conn.hashIndex.Add(mediaId, []uint64{contentHash})
+	} else {
+		conn.hashIndex.Remove(mediaId)
+	}
+	if generation == conn.hashIndexGeneration+1 {
+		conn.hashIndexGeneration = generation
+	}
+	return
+}
+
+func (conn *Database) getHashedMediaIdsByType(mediaType string) (ids map[string]bool, err error) {
+	rows, err := conn.db.Query(`SELECT media_id FROM media WHERE content_length > 0 AND type=$1`, mediaType)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	ids = map[string]bool{}
+	for rows.Next() {
+		var mediaId string
+		err = rows.Scan(&mediaId)
 		if err != nil {
 			return
 		}
-		m.ContentHash = uint64(sinedHash)
-		mediaList = append(mediaList, m)
+		ids[mediaId] = true
 	}
+	err = rows.Err()
+	return
+}
+
+func (conn *Database) getMediaHashSets(algorithm string, withTransforms bool) (byTransform map[string]map[string][]uint64, err error) {
+	byTransform = map[string]map[string][]uint64{}
+	if algorithm == ContentHashAlgorithm {
+		var index *HashIndex
+		index, err = conn.loadHashIndex()
+		if err != nil {
+			return
+		}
 
-	clusters := clusterMedia(mediaList)
+		index.mu.RLock()
+		hashSet := make(map[string][]uint64, len(index.hashes))
+		for id, hash := range index.hashes {
+			hashSet[id] = hash
+		}
+		index.mu.RUnlock()
+
+		byTransform[untransformed] = hashSet
+		if !withTransforms {
+			return
+		}
+	}
+
+	rows, err := conn.db.Query(`SELECT
+				media_hashes.media_id,
+				media_hashes.transform,
+				media_hashes.hash
+			FROM
+				media_hashes
+			JOIN
+				media ON media.media_id = media_hashes.media_id
+			WHERE
+				media.content_length > 0 AND media_hashes.algorithm=$1
+			`, algorithm)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var mediaId string
+		var transform string
+		var hashText string
+		err = rows.Scan(&mediaId, &transform, &hashText)
+		if err != nil {
+			return
+		}
+		if transform != untransformed && !withTransforms {
+			continue
+		}
+		if algorithm == ContentHashAlgorithm && transform == untransformed {
+			continue
+		}
+
+		hash, parseErr := diffhash.ParseHash(algorithm, hashText)
+		if parseErr != nil || hash.IsZero() {
+			continue
+		}
+		if byTransform[transform] == nil {
+			byTransform[transform] = map[string][]uint64{}
+		}
+		byTransform[transform][mediaId] = hash.Bits
+	}
+	err = rows.Err()
+	return
+}
+
+func (conn *Database) GetHashCluster(options HashClusterOptions) (duplicatedMediaList [][]HashClusterMember, err error) {
+	var include func(mediaId string) bool
+	if options.Type != "" {
+		var ids map[string]bool
+		ids, err = conn.getHashedMediaIdsByType(options.Type)
+		if err != nil {
+			return
+		}
+		include = func(mediaId string) bool { return ids[mediaId] }
+	}
+
+	var clusters map[string][]string
+	distance := contentHashDistance
+	if len(options.Algorithms) == 0 && !options.MatchTransforms {
+		var index *HashIndex
+		index, err = conn.loadHashIndex()
+		if err != nil {
+			return
+		}
+		clusters = index.Cluster(options.maxDistance(), include)
+	} else {
+		var hashSets map[string][]map[string][]uint64
+		hashSets, err = collectHashSets(options.algorithms(), func(algorithm string) (map[string]map[string][]uint64, error) {
+			return conn.getMediaHashSets(algorithm, options.MatchTransforms)
+		})
+		if err != nil {
+			return
+		}
+		clusters, distance = clusterHashSets(hashSets, options.maxDistance(), include)
+	}
 
 	for _, members := range clusters {
-		if len(members) <= 1 {
+		if len(members) < options.minSize() {
 			continue
 		}
 
@@ -83,8 +447,9 @@ func (conn *Database) GetHashCluster() (duplicatedMediaList [][]MediaRecord, err
 			return nil, err
 		}
 
-		duplicatedMediaList = append(duplicatedMediaList, mediaRecordList)
+		duplicatedMediaList = append(duplicatedMediaList, rankHashCluster(mediaRecordList, distance))
 	}
 
+	sortHashClusters(duplicatedMediaList)
 	return
 }
@@ -0,0 +1,65 @@
+package datasource
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type SimilarMedia struct {
+	MediaRecord
+	Distance int
+}
+
+func nearestHashMatches(index *HashIndex, contentHash uint64, maxDistance int, limit int) []HashMatch {
+	matches := index.Search([]uint64{contentHash}, min(maxDistance, MaxDuplicateDistance))
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Distance != matches[j].Distance {
+			return matches[i].Distance < matches[j].Distance
+		}
+		return matches[i].MediaId < matches[j].MediaId
+	})
+
+	if limit > 0 && len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches
+}
+
+func (conn *Database) GetSimilarMedia(contentHash uint64, maxDistance int, limit int) (similarMediaList []SimilarMedia, err error) {
+	index, err := conn.loadHashIndex()
+	if err != nil {
+		return
+	}
+
+	matches := nearestHashMatches(index, contentHash, maxDistance, limit)
+	if len(matches) == 0 {
+		return
+	}
+
+	placeholder := []string{}
+	values := []any{}
+	for no, match := range matches {
+		holder := fmt.Sprintf("$%d", no+1)
+		placeholder = append(placeholder, holder)
+		values = append(values, match.MediaId)
+	}
+
+	mediaRecordList, err := conn.GetMediaByQuery("media_id IN ("+strings.Join(placeholder, ",")+")", values...)
+	if err != nil {
+		return
+	}
+
+	mediaRecords := map[string]MediaRecord{}
+	for _, mediaRecord := range mediaRecordList {
+		mediaRecords[mediaRecord.MediaId] = mediaRecord
+	}
+
+	for _, match := range matches {
+		if mediaRecord, ok := mediaRecords[match.MediaId]; ok {
+			similarMediaList = append(similarMediaList, SimilarMedia{MediaRecord: mediaRecord, Distance: match.Distance})
+		}
+	}
+
+	return
+}
@@ -0,0 +1,235 @@
+package datasource
+
+import (
+	"fmt"
+	"math/bits"
+	"sync"
+)
+
+const (
+	hashChunkBits      = 16
+	hashChunksPerWord  = 64 / hashChunkBits
+	maxHashChunkRadius = 4
+)
+
+const DefaultDuplicateDistance = 8
+
+// MaxDuplicateDistance is the largest distance per 64 bits that Search serves
+// from the chunk buckets; anything above it would scan every hash.
+const MaxDuplicateDistance = (maxHashChunkRadius+1)*hashChunksPerWord - 1
+
+type HashMatch struct {
+	MediaId  string
+	Distance int
+}
+
+// HashIndex is a multi-index hash over the 16-bit chunks of hashes made of
+// one or more 64-bit words. Two hashes within distance d share at least one
+// chunk within d/chunks, so a search only visits the buckets near each chunk
+// of the query.
+type HashIndex struct {
+	mu     sync.RWMutex
+	words  int
+	hashes map[string][]uint64
+	chunks []hashChunkTable
+}
+
+// hashChunkTable keeps a bitmap of the occupied keys next to the buckets, so
+// probing the mostly empty neighborhood of a chunk rarely touches the map.
+type hashChunkTable struct {
+	occupied [1 << hashChunkBits / 64]uint64
+	buckets  map[uint16][]hashEntry
+}
+
+type hashEntry struct {
+	mediaId string
+	hash    []uint64
+}
+
+func NewHashIndex(words int) *HashIndex {
+	index := &HashIndex{
+		words:  words,
+		hashes: make(map[string][]uint64),
+		chunks: make([]hashChunkTable, words*hashChunksPerWord),
+	}
+	for i := range index.chunks {
+		index.chunks[i].buckets = make(map[uint16][]hashEntry)
+	}
+	return index
+}
+
+func hammingDistance(a, b []uint64) (distance int) {
+	for i := range a {
+		distance += bits.OnesCount64(a[i] ^ b[i])
+	}
+	return
+}
+
+func hashChunk(hash []uint64, i int) uint16 {
+	return uint16(hash[i/hashChunksPerWord] >> (i % hashChunksPerWord * hashChunkBits))
+}
+
+func (index *HashIndex) Len() int {
+	index.mu.RLock()
+	defer index.mu.RUnlock()
+	return len(index.hashes)
+}
+
+func (index *HashIndex) Hash(mediaId string) (hash []uint64, ok bool) {
+	index.mu.RLock()
+	defer index.mu.RUnlock()
+	hash, ok = index.hashes[mediaId]
+	return
+}
+
+func (index *HashIndex) Add(mediaId string, hash []uint64) (err error) {
+	if len(hash) != index.words {
+		return fmt.Errorf("hash of %s has %d words, index has %d", mediaId, len(hash), index.words)
+	}
+
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	if current, ok := index.hashes[mediaId]; ok {
+		if hammingDistance(current, hash) == 0 {
+			return
+		}
+		index.remove(mediaId, current)
+	}
+
+	hash = append([]uint64{}, hash...)
+	index.hashes[mediaId] = hash
+	for i := range index.chunks {
+		index.chunks[i].add(hashChunk(hash, i), hashEntry{mediaId, hash})
+	}
+	return
+}
+
+func (index *HashIndex) Remove(mediaId string) {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	if hash, ok := index.hashes[mediaId]; ok {
+		index.remove(mediaId, hash)
+	}
+}
+
+func (index *HashIndex) remove(mediaId string, hash []uint64) {
+	delete(index.hashes, mediaId)
+	for i := range index.chunks {
+		index.chunks[i].remove(hashChunk(hash, i), mediaId)
+	}
+}
+
+func (table *hashChunkTable) add(key uint16, entry hashEntry) {
+	table.occupied[key/64] |= 1 << (key % 64)
+	table.buckets[key] = append(table.buckets[key], entry)
+}
+
+func (table *hashChunkTable) remove(key uint16, mediaId string) {
+	bucket := table.buckets[key]
+	for j, entry := range bucket {
+		if entry.mediaId == mediaId {
+			bucket[j] = bucket[len(bucket)-1]
+			bucket = bucket[:len(bucket)-1]
+			break
+		}
+	}
+	if len(bucket) == 0 {
+		table.occupied[key/64] &^= 1 << (key % 64)
+		delete(table.buckets, key)
+	} else {
+		table.buckets[key] = bucket
+	}
+}
+
+func (table *hashChunkTable) bucket(key uint16) []hashEntry {
+	if table.occupied[key/64]&(1<<(key%64)) == 0 {
+		return nil
+	}
+	return table.buckets[key]
+}
+
+func (index *HashIndex) Search(hash []uint64, maxDistance int) (matches []HashMatch) {
+	index.mu.RLock()
+	defer index.mu.RUnlock()
+
+	if maxDistance < 0 || len(hash) != index.words {
+		return
+	}
+
+	radius := maxDistance / len(index.chunks)
+	if radius > maxHashChunkRadius {
+		for id, h := range index.hashes {
+			if d := hammingDistance(h, hash); d <= maxDistance {
+				matches = append(matches, HashMatch{MediaId: id, Distance: d})
+			}
+		}
+		return
+	}
+
+	for i := range index.chunks {
+		visitChunkNeighbors(hashChunk(hash, i), radius, func(key uint16) {
+			for _, entry := range index.chunks[i].bucket(key) {
+				if foundInEarlierChunk(entry.hash, hash, i, radius) {
+					continue
+				}
+				if d := hammingDistance(entry.hash, hash); d <= maxDistance {
+					matches = append(matches, HashMatch{MediaId: entry.mediaId, Distance: d})
+				}
+			}
+		})
+	}
+
+	return
+}
+
+func foundInEarlierChunk(a, b []uint64, chunk int, radius int) bool {
+	for i := 0; i < chunk; i++ {
+		if bits.OnesCount16(hashChunk(a, i)^hashChunk(b, i)) <= radius {
+			return true
+		}
+	}
+	return false
+}
+
+func visitChunkNeighbors(key uint16, radius int, visit func(uint16)) {
+	var walk func(v uint16, from int, left int)
+	walk = func(v uint16, from int, left int) {
+		visit(v)
+		if left == 0 {
+			return
+		}
+		for bit := from; bit < hashChunkBits; bit++ {
+			walk(v^(1<<bit), bit+1, left-1)
+		}
+	}
+	walk(key, 0, radius)
+}
+
+func (index *HashIndex) Cluster(maxDistance int, include func(mediaId string) bool) map[string][]string {
+	index.mu.RLock()
+	hashes := make(map[string][]uint64, len(index.hashes))
+	for id, hash := range index.hashes {
+		if include == nil || include(id) {
+			hashes[id] = hash
+		}
+	}
+	index.mu.RUnlock()
+
+	uf := NewUnionFind()
+	for id, hash := range hashes {
+		for _, match := range index.Search(hash, maxDistance) {
+			if _, ok := hashes[match.MediaId]; ok {
+				uf.Union(id, match.MediaId)
+			}
+		}
+	}
+
+	clusters := make(map[string][]string)
+	for id := range hashes {
+		root := uf.Find(id)
+		clusters[root] = append(clusters[root], id)
+	}
+	return clusters
+}
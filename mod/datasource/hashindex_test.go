@@ -0,0 +1,136 @@
+package datasource
+
+import (
+	"math/rand"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+const benchmarkHashCount = 20000
+
+// randomHashes returns n hashes where about half are near-duplicates of an
+// earlier hash, so both the matching and the non-matching paths are taken.
+func randomHashes(rnd *rand.Rand, n int, words int) map[string][]uint64 {
+	hashes := make(map[string][]uint64, n)
+	var previous []uint64
+	for i := 0; i < n; i++ {
+		hash := make([]uint64, words)
+		if previous != nil && rnd.Intn(2) == 0 {
+			copy(hash, previous)
+			for flips := rnd.Intn(16); flips > 0; flips-- {
+				bit := rnd.Intn(words * 64)
+				hash[bit/64] ^= 1 << (bit % 64)
+			}
+		} else {
+			for w := range hash {
+				hash[w] = rnd.Uint64()
+			}
+		}
+		hashes["m"+strconv.Itoa(i)] = hash
+		previous = hash
+	}
+	return hashes
+}
+
+func newTestHashIndex(t testing.TB, hashes map[string][]uint64, words int) *HashIndex {
+	index := NewHashIndex(words)
+	for id, hash := range hashes {
+		if err := index.Add(id, hash); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return index
+}
+
+func linearSearch(hashes map[string][]uint64, hash []uint64, maxDistance int) (matches []HashMatch) {
+	for id, h := range hashes {
+		if d := hammingDistance(h, hash); d <= maxDistance {
+			matches = append(matches, HashMatch{MediaId: id, Distance: d})
+		}
+	}
+	return
+}
+
+func sortHashMatches(matches []HashMatch) []HashMatch {
+	sort.Slice(matches, func(i, j int) bool { return matches[i].MediaId < matches[j].MediaId })
+	return matches
+}
+
+func TestHashIndexSearchMatchesLinearScan(t *testing.T) {
+	for _, words := range []int{1, 4} {
+		rnd := rand.New(rand.NewSource(int64(words)))
+		hashes := randomHashes(rnd, 2000, words)
+		index := newTestHashIndex(t, hashes, words)
+
+		for id := range hashes {
+			if rnd.Intn(10) == 0 {
+				index.Remove(id)
+				delete(hashes, id)
+			}
+		}
+
+		queries := make([][]uint64, 0, 100)
+		for _, hash := range hashes {
+			if len(queries) == cap(queries) {
+				break
+			}
+			queries = append(queries, hash)
+		}
+
+		for radius := 0; radius <= 12; radius++ {
+			for _, query := range queries {
+				got := sortHashMatches(index.Search(query, radius))
+				want := sortHashMatches(linearSearch(hashes, query, radius))
+				if len(got) != len(want) {
+					t.Fatalf("words %d radius %d: got %d matches, want %d", words, radius, len(got), len(want))
+				}
+				for i := range want {
+					if got[i] != want[i] {
+						t.Fatalf("words %d radius %d: got %v, want %v", words, radius, got[i], want[i])
+					}
+				}
+			}
+		}
+	}
+}
+
+func clusterPairwise(hashes map[string][]uint64, maxDistance int) map[string][]string {
+	ids := make([]string, 0, len(hashes))
+	for id := range hashes {
+		ids = append(ids, id)
+	}
+
+	uf := NewUnionFind()
+	for i := range ids {
+		for j := i + 1; j < len(ids); j++ {
+			if hammingDistance(hashes[ids[i]], hashes[ids[j]]) <= maxDistance {
+				uf.Union(ids[i], ids[j])
+			}
+		}
+	}
+
+	clusters := make(map[string][]string)
+	for _, id := range ids {
+		root := uf.Find(id)
+		clusters[root] = append(clusters[root], id)
+	}
+	return clusters
+}
+
+func BenchmarkClusterPairwise(b *testing.B) {
+	hashes := randomHashes(rand.New(rand.NewSource(1)), benchmarkHashCount, 1)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		clusterPairwise(hashes, DefaultDuplicateDistance)
+	}
+}
+
+func BenchmarkHashIndexCluster(b *testing.B) {
+	hashes := randomHashes(rand.New(rand.NewSource(1)), benchmarkHashCount, 1)
+	index := newTestHashIndex(b, hashes, 1)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		index.Cluster(DefaultDuplicateDistance, nil)
+	}
+}
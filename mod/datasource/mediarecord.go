@@ -2,6 +2,7 @@ package datasource
 
 import (
 	"database/sql"
+	"path/filepath"
 	"strings"
 )
 
@@ -16,7 +17,15 @@ type MediaRecord struct {
 	ContentLength  sql.NullInt64
 	ContentHash    sql.NullInt64
 	CachePath      sql.NullString
+	MimeType       sql.NullString
+	Width          sql.NullInt32
+	Height         sql.NullInt32
+	Codec          sql.NullString
+	Bitrate        sql.NullInt64
+	FrameRate      sql.NullFloat64
 	HasThumbnail   bool
+	HasPreview     bool
+	HasSprite      bool
 	Removed        bool
 }
 
@@ -24,7 +33,14 @@ type MediaRecordComplement struct {
 	MediaRecord
 	HasCache      bool
 	MediaPath     sql.NullString
+	FilePath      sql.NullString
 	ThumbnailPath sql.NullString
+	PreviewPath   sql.NullString
+	SpritePath    sql.NullString
+}
+
+func (m MediaRecord) isLegacyCachePath() bool {
+	return filepath.IsAbs(m.CachePath.String) && strings.Contains(m.CachePath.String, ".cache")
 }
 
 func (m MediaRecord) getRelativePath() string {
@@ -39,8 +55,12 @@ func (m MediaRecord) HasCache() bool {
 func (m MediaRecord) GetMediaPath() sql.NullString {
 	var mediaPath sql.NullString
 	if m.CachePath.Valid {
-		mediaPath.String = m.getRelativePath()
 		mediaPath.Valid = true
+		if m.isLegacyCachePath() {
+			mediaPath.String = m.getRelativePath()
+		} else {
+			mediaPath.String = GetSelfName() + "/file/" + m.MediaId
+		}
 	}
 	return mediaPath
 }
@@ -48,11 +68,14 @@ func (m MediaRecord) GetMediaPath() sql.NullString {
 func (m MediaRecord) GetThumbnailPath() sql.NullString {
 	var thumbPath sql.NullString
 	if m.CachePath.Valid {
-		thumbPath.String = m.getRelativePath()
-		thumbPath.Valid = true
 		if m.HasThumbnail {
 			thumbPath.String = GetSelfName() + "/thumbnail/" + m.MediaId
-		} else if m.Type != "photo" {
+			thumbPath.Valid = true
+		} else if m.Type == "photo" {
+			thumbPath = m.GetMediaPath()
+		} else if m.isLegacyCachePath() {
+			thumbPath.String = m.getRelativePath()
+			thumbPath.Valid = true
 			ext := strings.LastIndex(thumbPath.String, ".")
 			thumbPath.String = thumbPath.String[:ext] + "_thumb.jpg"
 		}
@@ -60,11 +83,41 @@ func (m MediaRecord) GetThumbnailPath() sql.NullString {
 	return thumbPath
 }
 
+func (m MediaRecord) GetFilePath() sql.NullString {
+	var filePath sql.NullString
+	if m.CachePath.Valid {
+		filePath.String = GetSelfName() + "/file/" + m.MediaId
+		filePath.Valid = true
+	}
+	return filePath
+}
+
+func (m MediaRecord) GetPreviewPath() sql.NullString {
+	var previewPath sql.NullString
+	if m.CachePath.Valid && m.HasPreview {
+		previewPath.String = GetSelfName() + "/preview/" + m.MediaId
+		previewPath.Valid = true
+	}
+	return previewPath
+}
+
+func (m MediaRecord) GetSpritePath() sql.NullString {
+	var spritePath sql.NullString
+	if m.CachePath.Valid && m.HasSprite {
+		spritePath.String = GetSelfName() + "/sprite/" + m.MediaId
+		spritePath.Valid = true
+	}
+	return spritePath
+}
+
 func (m MediaRecord) ToCompletion() MediaRecordComplement {
 	return MediaRecordComplement{
 		MediaRecord:   m,
 		HasCache:      m.HasCache(),
 		MediaPath:     m.GetMediaPath(),
+		FilePath:      m.GetFilePath(),
 		ThumbnailPath: m.GetThumbnailPath(),
+		PreviewPath:   m.GetPreviewPath(),
+		SpritePath:    m.GetSpritePath(),
 	}
 }
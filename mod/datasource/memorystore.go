@@ -0,0 +1,845 @@
+package datasource
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"diffhash"
+)
+
+type memoryRow struct {
+	MediaRecord
+
+	thumbnailKey     sql.NullString
+	previewKey       sql.NullString
+	spriteKey        sql.NullString
+	previewFailed    bool
+	spriteFailed     bool
+	downloadAttempts int
+	downloadFailed   bool
+	lastError        sql.NullString
+	createdAt        time.Time
+	updatedAt        time.Time
+}
+
+type thumbnailSize struct {
+	mediaId string
+	width   uint
+}
+
+type mediaHashKey struct {
+	mediaId   string
+	algorithm string
+	transform string
+}
+
+type MemoryStore struct {
+	mu          sync.RWMutex
+	rows        map[string]*memoryRow
+	thumbnails  map[thumbnailSize]string
+	mediaHashes map[mediaHashKey]string
+	hashIndex   *HashIndex
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		rows:        make(map[string]*memoryRow),
+		thumbnails:  make(map[thumbnailSize]string),
+		mediaHashes: make(map[mediaHashKey]string),
+		hashIndex:   NewHashIndex(1),
+	}
+}
+
+func (store *MemoryStore) Close() {}
+
+func (store *MemoryStore) UpsertMedia(columns []string, valueTable [][]any) (err error) {
+	if len(valueTable) == 0 {
+		err = fmt.Errorf("no data")
+		return
+	}
+
+	colLen := len(columns)
+	for i, row := range valueTable {
+		if colLen != len(row) {
+			err = fmt.Errorf("diferent table row / columns: %d row", i)
+			return
+		}
+	}
+
+	rows := []*memoryRow{}
+	for _, row := range valueTable {
+		now := time.Now()
+		r := &memoryRow{
+			MediaRecord: MediaRecord{Timestamp: uint64(now.UnixMilli())},
+			createdAt:   now,
+			updatedAt:   now,
+		}
+		for j, col := range columns {
+			if err = r.setColumn(col, row[j]); err != nil {
+				return
+			}
+		}
+		if r.MediaId == "" {
+			err = fmt.Errorf("media_id is required")
+			return
+		}
+		rows = append(rows, r)
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	for _, r := range rows {
+		if _, ok := store.rows[r.MediaId]; ok {
+			continue
+		}
+		store.rows[r.MediaId] = r
+	}
+
+	return
+}
+
+func (store *MemoryStore) GetMedia() (mediaRecordList []MediaRecord, err error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	for _, r := range store.sortedRows() {
+		if r.Removed {
+			continue
+		}
+		m := r.record()
+		m.ContentHash = sql.NullInt64{}
+		mediaRecordList = append(mediaRecordList, m)
+	}
+
+	return
+}
+
+func (store *MemoryStore) GetMediaByID(id string) (mediaRecord MediaRecord, err error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	r, ok := store.rows[id]
+	if !ok {
+		err = sql.ErrNoRows
+		return
+	}
+
+	mediaRecord = r.record()
+	return
+}
+
+func (store *MemoryStore) GetMediaByCachePath(patterns []string) (mediaRecordList []MediaRecord, err error) {
+	matchers := []*regexp.Regexp{}
+	for _, pattern := range patterns {
+		var matcher *regexp.Regexp
+		matcher, err = likePattern("%" + pattern + "%")
+		if err != nil {
+			return
+		}
+		matchers = append(matchers, matcher)
+	}
+
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	for _, r := range store.rows {
+		if !r.CachePath.Valid {
+			continue
+		}
+		for _, matcher := range matchers {
+			if matcher.MatchString(r.CachePath.String) {
+				mediaRecordList = append(mediaRecordList, r.record())
+				break
+			}
+		}
+	}
+
+	return
+}
+
+func (store *MemoryStore) GetMediaWithCache() (mediaRecordList []MediaRecord, err error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	for _, r := range store.rows {
+		if r.CachePath.Valid {
+			mediaRecordList = append(mediaRecordList, r.record())
+		}
+	}
+
+	return
+}
+
+func (store *MemoryStore) GetCatalog(date string) (mediaRecordList []MediaRecord, err error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	for _, r := range store.sortedRows() {
+		if !r.HasCache() || !r.CachePath.Valid || timestampToDate(r.Timestamp) != date {
+			continue
+		}
+		m := r.record()
+		m.ContentHash = sql.NullInt64{}
+		m.Removed = false
+		mediaRecordList = append(mediaRecordList, m)
+	}
+
+	return
+}
+
+func (store *MemoryStore) GetCatalogIndex(minSize uint64) (dates []string, err error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	found := map[string]bool{}
+	for _, r := range store.rows {
+		if !r.ContentLength.Valid || r.ContentLength.Int64 <= int64(minSize) || !r.CachePath.Valid {
+			continue
+		}
+		date := timestampToDate(r.Timestamp)
+		if !found[date] {
+			found[date] = true
+			dates = append(dates, date)
+		}
+	}
+
+	sort.Sort(sort.Reverse(sort.StringSlice(dates)))
+	return
+}
+
+func (store *MemoryStore) GetHashCluster(options HashClusterOptions) (duplicatedMediaList [][]HashClusterMember, err error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	var include func(mediaId string) bool
+	if options.Type != "" {
+		include = func(mediaId string) bool { return store.rows[mediaId].Type == options.Type }
+	}
+
+	var clusters map[string][]string
+	distance := contentHashDistance
+	if len(options.Algorithms) == 0 && !options.MatchTransforms {
+		clusters = store.hashIndex.Cluster(options.maxDistance(), include)
+	} else {
+		hashSets, _ := collectHashSets(options.algorithms(), func(algorithm string) (map[string]map[string][]uint64, error) {
+			return store.getMediaHashSets(algorithm, options.MatchTransforms), nil
+		})
+		clusters, distance = clusterHashSets(hashSets, options.maxDistance(), include)
+	}
+
+	for _, members := range clusters {
+		if len(members) < options.minSize() {
+			continue
+		}
+
+		mediaRecordList := []MediaRecord{}
+		for _, id := range members {
+			mediaRecordList = append(mediaRecordList, store.rows[id].record())
+		}
+		duplicatedMediaList = append(duplicatedMediaList, rankHashCluster(mediaRecordList, distance))
+	}
+
+	sortHashClusters(duplicatedMediaList)
+	return
+}
+
+func (store *MemoryStore) GetSimilarMedia(contentHash uint64, maxDistance int, limit int) (similarMediaList []SimilarMedia, err error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	for _, match := range nearestHashMatches(store.hashIndex, contentHash, maxDistance, limit) {
+		similarMediaList = append(similarMediaList, SimilarMedia{MediaRecord: store.rows[match.MediaId].record(), Distance: match.Distance})
+	}
+
+	return
+}
+
+func (store *MemoryStore) GetThumbnailByID(id string) (thumbnail []byte, err error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	if _, ok := store.rows[id]; !ok {
+		err = sql.ErrNoRows
+	}
+
+	return
+}
+
+func (store *MemoryStore) GetThumbnailKeyByID(id string) (key string, err error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	r, ok := store.rows[id]
+	if !ok {
+		err = sql.ErrNoRows
+		return
+	}
+
+	key = r.thumbnailKey.String
+	return
+}
+
+func (store *MemoryStore) GetThumbnailKeyBySize(id string, width uint) (key string, err error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	key, ok := store.thumbnails[thumbnailSize{id, width}]
+	if !ok {
+		err = sql.ErrNoRows
+	}
+
+	return
+}
+
+func (store *MemoryStore) SetThumbnailKeyBySize(mediaId string, width uint, key string) (err error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	store.thumbnails[thumbnailSize{mediaId, width}] = key
+	return
+}
+
+func (store *MemoryStore) GetPreviewKeyByID(id string) (key string, err error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	r, ok := store.rows[id]
+	if !ok {
+		err = sql.ErrNoRows
+		return
+	}
+
+	key = r.previewKey.String
+	return
+}
+
+func (store *MemoryStore) GetSpriteKeyByID(id string) (key string, err error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	r, ok := store.rows[id]
+	if !ok {
+		err = sql.ErrNoRows
+		return
+	}
+
+	key = r.spriteKey.String
+	return
+}
+
+func (store *MemoryStore) GetLegacyThumbnailMediaIds() (mediaIds []string, err error) {
+	return
+}
+
+func (store *MemoryStore) GetNoCacheMedia() (mediaList []map[string]any, err error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	for _, r := range store.sortedRows() {
+		if r.Removed || r.ContentLength.Valid || r.downloadFailed || r.downloadAttempts >= MaxDownloadAttempts {
+			continue
+		}
+		mediaList = append(mediaList, map[string]any{
+			"mediaId":   r.MediaId,
+			"parentUrl": r.ParentUrl,
+			"type":      r.Type,
+			"url":       r.Url,
+			"timestamp": r.Timestamp,
+			"videoUrl":  r.VideoUrl,
+		})
+	}
+
+	return
+}
+
+func (store *MemoryStore) GetCachedVideoMedia() (cachedVideoMediaList []CachedVideoMedia, err error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	for _, r := range store.sortedRows() {
+		if r.Type != "video" || r.Removed || !r.ContentLength.Valid || !r.CachePath.Valid {
+			continue
+		}
+		cachedVideoMediaList = append(cachedVideoMediaList, CachedVideoMedia{
+			Id:   r.MediaId,
+			Path: r.CachePath.String,
+		})
+	}
+
+	return
+}
+
+func (store *MemoryStore) GetMediaWithoutPreview() (animatedMediaList []AnimatedMedia, err error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	for _, r := range store.sortedRows() {
+		if (r.Type != "video" && r.Type != "animated_gif") || r.Removed || !r.HasCache() || !r.CachePath.Valid {
+			continue
+		}
+		needsPreview := !r.previewKey.Valid && !r.previewFailed
+		needsSprite := !r.spriteKey.Valid && !r.spriteFailed
+		if !needsPreview && !needsSprite {
+			continue
+		}
+		animatedMedia := AnimatedMedia{
+			Id:           r.MediaId,
+			Type:         r.Type,
+			Path:         r.CachePath.String,
+			NeedsPreview: needsPreview,
+			NeedsSprite:  needsSprite,
+		}
+		if r.DurationMillis.Valid && r.DurationMillis.Int32 > 0 {
+			animatedMedia.DurationMillis = uint(r.DurationMillis.Int32)
+		}
+		animatedMediaList = append(animatedMediaList, animatedMedia)
+	}
+
+	return
+}
+
+func (store *MemoryStore) GetUnprobedMedia() (cachedMediaList []CachedMedia, err error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	for _, r := range store.sortedRows() {
+		if r.Removed || !r.HasCache() || !r.CachePath.Valid || r.Width.Valid {
+			continue
+		}
+		cachedMediaList = append(cachedMediaList, CachedMedia{
+			Id:   r.MediaId,
+			Type: r.Type,
+			Path: r.CachePath.String,
+		})
+	}
+
+	return
+}
+
+func (store *MemoryStore) GetUnhashedMedia() (unhashedMediaList []UnhashedMedia, err error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	for _, r := range store.rows {
+		if !r.HasCache() || r.ContentHash.Valid || !r.thumbnailKey.Valid {
+			continue
+		}
+		unhashedMediaList = append(unhashedMediaList, UnhashedMedia{
+			MediaId:      r.MediaId,
+			ThumbnailKey: r.thumbnailKey.String,
+		})
+	}
+
+	return
+}
+
+func (store *MemoryStore) GetMediaWithoutHash(algorithm string, transform string) (unhashedMediaList []UnhashedMedia, err error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	for _, r := range store.rows {
+		if !r.HasCache() || !r.thumbnailKey.Valid {
+			continue
+		}
+		if _, ok := store.mediaHashes[mediaHashKey{r.MediaId, algorithm, transform}]; ok {
+			continue
+		}
+		unhashedMediaList = append(unhashedMediaList, UnhashedMedia{
+			MediaId:      r.MediaId,
+			ThumbnailKey: r.thumbnailKey.String,
+		})
+	}
+
+	return
+}
+
+func (store *MemoryStore) SetCacheData(mediaId string, contentLength uint64, contentHash uint64, cachePath string) (err error) {
+	store.updateByID(mediaId, func(r *memoryRow) bool {
+		r.ContentLength = sql.NullInt64{Int64: int64(contentLength), Valid: true}
+		r.ContentHash = sql.NullInt64{Int64: int64(contentHash), Valid: true}
+		r.CachePath = sql.NullString{String: cachePath, Valid: true}
+		r.lastError = sql.NullString{}
+		store.indexHash(r)
+		return true
+	})
+	return
+}
+
+func (store *MemoryStore) SetCachePaths(cachePaths map[string]string) (err error) {
+	store.update(func(r *memoryRow) bool {
+		cachePath, ok := cachePaths[r.MediaId]
+		if !ok {
+			return false
+		}
+		r.CachePath = sql.NullString{String: cachePath, Valid: true}
+		return true
+	})
+	return
+}
+
+func (store *MemoryStore) SetMediaMetadata(mediaId string, width uint, height uint, durationMillis uint, codec string, bitrate uint64, frameRate float64) (err error) {
+	store.updateByID(mediaId, func(r *memoryRow) bool {
+		r.Width = sql.NullInt32{Int32: int32(width), Valid: true}
+		r.Height = sql.NullInt32{Int32: int32(height), Valid: true}
+		if durationMillis > 0 {
+			r.DurationMillis = sql.NullInt32{Int32: int32(durationMillis), Valid: true}
+		}
+		r.Codec = sql.NullString{String: codec, Valid: codec != ""}
+		r.Bitrate = sql.NullInt64{Int64: int64(bitrate), Valid: true}
+		r.FrameRate = sql.NullFloat64{Float64: frameRate, Valid: true}
+		return true
+	})
+	return
+}
+
+func (store *MemoryStore) SetMimeType(mediaId string, mimeType string) (err error) {
+	store.updateByID(mediaId, func(r *memoryRow) bool {
+		r.MimeType = sql.NullString{String: mimeType, Valid: true}
+		return true
+	})
+	return
+}
+
+func (store *MemoryStore) RecordDownloadFailure(mediaId string, lastError string, permanent bool) (err error) {
+	store.updateByID(mediaId, func(r *memoryRow) bool {
+		r.downloadAttempts++
+		r.downloadFailed = r.downloadFailed || permanent
+		r.lastError = sql.NullString{String: lastError, Valid: true}
+		return true
+	})
+	return
+}
+
+func (store *MemoryStore) RequeueMedia(mediaId string) (err error) {
+	store.updateByID(mediaId, func(r *memoryRow) bool {
+		r.ContentLength = sql.NullInt64{}
+		r.ContentHash = sql.NullInt64{}
+		r.CachePath = sql.NullString{}
+		r.downloadAttempts = 0
+		r.downloadFailed = false
+		r.lastError = sql.NullString{}
+		r.previewFailed = false
+		r.spriteFailed = false
+		store.indexHash(r)
+		for key := range store.mediaHashes {
+			if key.mediaId == mediaId {
+				delete(store.mediaHashes, key)
+			}
+		}
+		return true
+	})
+	return
+}
+
+func (store *MemoryStore) SetThumbnailKey(mediaId string, key string) (err error) {
+	store.updateByID(mediaId, func(r *memoryRow) bool {
+		r.thumbnailKey = sql.NullString{String: key, Valid: true}
+		return true
+	})
+	return
+}
+
+func (store *MemoryStore) SetPreviewKey(mediaId string, key string) (err error) {
+	store.updateByID(mediaId, func(r *memoryRow) bool {
+		r.previewKey = sql.NullString{String: key, Valid: true}
+		return true
+	})
+	return
+}
+
+func (store *MemoryStore) SetSpriteKey(mediaId string, key string) (err error) {
+	store.updateByID(mediaId, func(r *memoryRow) bool {
+		r.spriteKey = sql.NullString{String: key, Valid: true}
+		return true
+	})
+	return
+}
+
+func (store *MemoryStore) SetPreviewFailed(mediaId string) (err error) {
+	store.updateByID(mediaId, func(r *memoryRow) bool {
+		r.previewFailed = true
+		return true
+	})
+	return
+}
+
+func (store *MemoryStore) SetSpriteFailed(mediaId string) (err error) {
+	store.updateByID(mediaId, func(r *memoryRow) bool {
+		r.spriteFailed = true
+		return true
+	})
+	return
+}
+
+func (store *MemoryStore) SetContentHashData(mediaId string, contentHash uint64) (err error) {
+	store.updateByID(mediaId, func(r *memoryRow) bool {
+		r.ContentHash = sql.NullInt64{Int64: int64(contentHash), Valid: true}
+		store.indexHash(r)
+		return true
+	})
+	return
+}
+
+func (store *MemoryStore) GetBlobKeys() (keys []string, err error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	seen := map[string]bool{}
+	add := func(key sql.NullString) {
+		if key.Valid && !seen[key.String] {
+			seen[key.String] = true
+			keys = append(keys, key.String)
+		}
+	}
+	for _, r := range store.rows {
+		add(r.thumbnailKey)
+		add(r.previewKey)
+		add(r.spriteKey)
+	}
+	for _, key := range store.thumbnails {
+		add(sql.NullString{String: key, Valid: true})
+	}
+
+	return
+}
+
+func (store *MemoryStore) SetMediaHash(mediaId string, algorithm string, transform string, hash string) (err error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	store.mediaHashes[mediaHashKey{mediaId, algorithm, transform}] = hash
+	return
+}
+
+func (store *MemoryStore) getMediaHashSets(algorithm string, withTransforms bool) map[string]map[string][]uint64 {
+	byTransform := map[string]map[string][]uint64{}
+	if algorithm == ContentHashAlgorithm {
+		hashSet := map[string][]uint64{}
+		for _, r := range store.rows {
+			if r.HasCache() && r.ContentHash.Valid && r.ContentHash.Int64 != 0 {
+				hashSet[r.MediaId] = []uint64{uint64(r.ContentHash.Int64)}
+			}
+		}
+		byTransform[untransformed] = hashSet
+	}
+
+	for key, hashText := range store.mediaHashes {
+		if key.algorithm != algorithm || (key.transform != untransformed && !withTransforms) {
+			continue
+		}
+		if algorithm == ContentHashAlgorithm && key.transform == untransformed {
+			continue
+		}
+		r, ok := store.rows[key.mediaId]
+		if !ok || !r.HasCache() {
+			continue
+		}
+		hash, err := diffhash.ParseHash(algorithm, hashText)
+		if err != nil || hash.IsZero() {
+			continue
+		}
+		if byTransform[key.transform] == nil {
+			byTransform[key.transform] = map[string][]uint64{}
+		}
+		byTransform[key.transform][key.mediaId] = hash.Bits
+	}
+	return byTransform
+}
+
+func (store *MemoryStore) DeleteMediaAll() (err error) {
+	store.update(func(r *memoryRow) bool {
+		if r.Removed {
+			return false
+		}
+		r.Removed = true
+		return true
+	})
+	return
+}
+
+func (store *MemoryStore) DeleteMediaCached() (err error) {
+	store.update(func(r *memoryRow) bool {
+		if r.Removed || !r.ContentLength.Valid {
+			return false
+		}
+		r.Removed = true
+		return true
+	})
+	return
+}
+
+func (store *MemoryStore) DeleteMedia(id string) (err error) {
+	store.updateByID(id, func(r *memoryRow) bool {
+		if r.Removed {
+			return false
+		}
+		r.Removed = true
+		return true
+	})
+	return
+}
+
+func (store *MemoryStore) DeleteCacheFile(id string) (err error) {
+	store.updateByID(id, func(r *memoryRow) bool {
+		r.ContentLength = sql.NullInt64{Int64: 0, Valid: true}
+		r.CachePath = sql.NullString{}
+		r.Removed = true
+		store.indexHash(r)
+		return true
+	})
+	return
+}
+
+func (store *MemoryStore) indexHash(r *memoryRow) {
+	if r.HasCache() && r.ContentHash.Valid && r.ContentHash.Int64 != 0 {
+		store.hashIndex.Add(r.MediaId, []uint64{uint64(r.ContentHash.Int64)})
+	} else {
+		store.hashIndex.Remove(r.MediaId)
+	}
+}
+
+func (store *MemoryStore) update(apply func(r *memoryRow) bool) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	now := time.Now()
+	for _, r := range store.rows {
+		if apply(r) {
+			r.updatedAt = now
+		}
+	}
+}
+
+func (store *MemoryStore) updateByID(mediaId string, apply func(r *memoryRow) bool) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if r, ok := store.rows[mediaId]; ok && apply(r) {
+		r.updatedAt = time.Now()
+	}
+}
+
+func (store *MemoryStore) sortedRows() []*memoryRow {
+	rows := make([]*memoryRow, 0, len(store.rows))
+	for _, r := range store.rows {
+		rows = append(rows, r)
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		return rows[i].Timestamp > rows[j].Timestamp
+	})
+	return rows
+}
+
+func (r *memoryRow) record() MediaRecord {
+	m := r.MediaRecord
+	m.HasThumbnail = r.thumbnailKey.Valid
+	m.HasPreview = r.previewKey.Valid
+	m.HasSprite = r.spriteKey.Valid
+	return m
+}
+
+func (r *memoryRow) setColumn(column string, value any) (err error) {
+	switch column {
+	case "media_id":
+		r.MediaId, err = toString(value)
+	case "parent_url":
+		r.ParentUrl, err = toString(value)
+	case "type":
+		r.Type, err = toString(value)
+	case "url":
+		r.Url, err = toString(value)
+	case "timestamp":
+		var v sql.NullInt64
+		if v, err = toNullInt64(value); err == nil && v.Valid {
+			r.Timestamp = uint64(v.Int64)
+		}
+	case "duration_millis":
+		var v sql.NullInt64
+		if v, err = toNullInt64(value); err == nil {
+			r.DurationMillis = sql.NullInt32{Int32: int32(v.Int64), Valid: v.Valid}
+		}
+	case "video_url":
+		if value == nil {
+			r.VideoUrl = sql.NullString{}
+		} else {
+			r.VideoUrl.String, err = toString(value)
+			r.VideoUrl.Valid = err == nil
+		}
+	default:
+		err = fmt.Errorf("unsupported column: %s", column)
+	}
+	return
+}
+
+func toString(value any) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case []byte:
+		return string(v), nil
+	case nil:
+		return "", fmt.Errorf("null value")
+	}
+	return fmt.Sprint(value), nil
+}
+
+func toNullInt64(value any) (sql.NullInt64, error) {
+	var n int64
+	switch v := value.(type) {
+	case nil:
+		return sql.NullInt64{}, nil
+	case int:
+		n = int64(v)
+	case int32:
+		n = int64(v)
+	case int64:
+		n = v
+	case uint:
+		n = int64(v)
+	case uint32:
+		n = int64(v)
+	case uint64:
+		n = int64(v)
+	case float64:
+		n = int64(v)
+	case string:
+		i, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return sql.NullInt64{}, err
+		}
+		n = i
+	default:
+		return sql.NullInt64{}, fmt.Errorf("unsupported value type: %T", value)
+	}
+	return sql.NullInt64{Int64: n, Valid: true}, nil
+}
+
+// likePattern compiles a SQL LIKE pattern, where % matches any run of
+// characters and _ matches a single one.
+func likePattern(pattern string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("(?s)^")
+	for _, c := range pattern {
+		switch c {
+		case '%':
+			b.WriteString(".*")
+		case '_':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+func timestampToDate(timestamp uint64) string {
+	return time.Unix(int64(timestamp/1000), 0).Format("2006-01-02")
+}
@@ -0,0 +1,234 @@
+package datasource
+
+import (
+	"fmt"
+)
+
+type Migration struct {
+	Version     int
+	Description string
+
+	up func(d dialect) []string
+}
+
+var migrations = []Migration{
+	{
+		Version:     1,
+		Description: "create media table",
+		up: func(d dialect) []string {
+			return []string{d.createMediaTable()}
+		},
+	},
+	{
+		Version:     2,
+		Description: "record download attempts and failures",
+		up: func(d dialect) []string {
+			return []string{
+				`ALTER TABLE media ADD COLUMN download_attempts INTEGER NOT NULL DEFAULT 0`,
+				`ALTER TABLE media ADD COLUMN download_failed BOOLEAN NOT NULL DEFAULT FALSE`,
+				`ALTER TABLE media ADD COLUMN last_error TEXT`,
+			}
+		},
+	},
+	{
+		Version:     3,
+		Description: "store detected mime type",
+		up: func(d dialect) []string {
+			return []string{`ALTER TABLE media ADD COLUMN mime_type TEXT`}
+		},
+	},
+	{
+		Version:     4,
+		Description: "create thumbnails table",
+		up: func(d dialect) []string {
+			return []string{`CREATE TABLE thumbnails(
+				media_id   TEXT NOT NULL,
+				width      INTEGER NOT NULL,
+				blob_key   TEXT NOT NULL,
+				created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				PRIMARY KEY (media_id, width)
+			)`}
+		},
+	},
+	{
+		Version:     5,
+		Description: "reference thumbnails in the blob store",
+		up: func(d dialect) []string {
+			return []string{`ALTER TABLE media ADD COLUMN thumbnail_key TEXT`}
+		},
+	},
+	{
+		Version:     6,
+		Description: "reference video previews and sprite sheets",
+		up: func(d dialect) []string {
+			return []string{
+				`ALTER TABLE media ADD COLUMN preview_key TEXT`,
+				`ALTER TABLE media ADD COLUMN sprite_key TEXT`,
+				`ALTER TABLE media ADD COLUMN preview_failed BOOLEAN NOT NULL DEFAULT FALSE`,
+				`ALTER TABLE media ADD COLUMN sprite_failed BOOLEAN NOT NULL DEFAULT FALSE`,
+			}
+		},
+	},
+	{
+		Version:     7,
+		Description: "record probed media metadata",
+		up: func(d dialect) []string {
+			return []string{
+				`ALTER TABLE media ADD COLUMN width INTEGER`,
+				`ALTER TABLE media ADD COLUMN height INTEGER`,
+				`ALTER TABLE media ADD COLUMN codec TEXT`,
+				`ALTER TABLE media ADD COLUMN bitrate BIGINT`,
+				`ALTER TABLE media ADD COLUMN frame_rate DOUBLE PRECISION`,
+			}
+		},
+	},
+	{
+		Version:     8,
+		Description: "store perceptual hashes per algorithm and transform",
+		up: func(d dialect) []string {
+			return []string{`CREATE TABLE media_hashes(
+				media_id   TEXT NOT NULL,
+				algorithm  TEXT NOT NULL,
+				transform  TEXT NOT NULL DEFAULT '',
+				hash       TEXT NOT NULL,
+				created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				PRIMARY KEY (media_id, algorithm, transform)
+			)`}
+		},
+	},
+	{
+		Version:     9,
+		Description: "count hash writes for the hash index",
+		up: func(d dialect) []string {
+			return []string{
+				`CREATE TABLE hash_generation(generation BIGINT NOT NULL)`,
+				`INSERT INTO hash_generation (generation) VALUES (0)`,
+			}
+		},
+	},
+}
+
+type SchemaError struct {
+	Current int
+	Latest  int
+}
+
+func (e *SchemaError) Error() string {
+	if e.Current > e.Latest {
+		return fmt.Sprintf("database schema version %d is newer than this binary supports (%d)", e.Current, e.Latest)
+	}
+	return fmt.Sprintf("database schema version %d is out of date (latest %d): run with -migrate", e.Current, e.Latest)
+}
+
+func (e *SchemaError) IsNewer() bool {
+	return e.Current > e.Latest
+}
+
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+func (conn *Database) ensureSchemaVersionTable() (err error) {
+	_, err = conn.db.Exec(`CREATE TABLE IF NOT EXISTS schema_version(
+				version     INTEGER PRIMARY KEY,
+				description TEXT NOT NULL,
+				applied_at  TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+			)`)
+	return
+}
+
+func (conn *Database) SchemaVersion() (version int, err error) {
+	var tables int
+	err = conn.db.QueryRow(conn.dialect.tableExists(), "schema_version").Scan(&tables)
+	if err != nil || tables == 0 {
+		return
+	}
+
+	err = conn.db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&version)
+	return
+}
+
+func (conn *Database) PendingMigrations() (pending []Migration, err error) {
+	version, err := conn.SchemaVersion()
+	if err != nil {
+		return
+	}
+
+	for _, m := range migrations {
+		if m.Version > version {
+			pending = append(pending, m)
+		}
+	}
+
+	return
+}
+
+func (conn *Database) CheckSchema() (err error) {
+	version, err := conn.SchemaVersion()
+	if err != nil {
+		return
+	}
+
+	latest := LatestSchemaVersion()
+	if version != latest {
+		err = &SchemaError{Current: version, Latest: latest}
+	}
+
+	return
+}
+
+func (conn *Database) Migrate() (applied []Migration, err error) {
+	if err = conn.ensureSchemaVersionTable(); err != nil {
+		return
+	}
+
+	version, err := conn.SchemaVersion()
+	if err != nil {
+		return
+	}
+
+	if latest := LatestSchemaVersion(); version > latest {
+		err = &SchemaError{Current: version, Latest: latest}
+		return
+	}
+
+	pending, err := conn.PendingMigrations()
+	if err != nil {
+		return
+	}
+
+	for _, m := range pending {
+		if err = conn.applyMigration(m); err != nil {
+			err = fmt.Errorf("migration %d (%s): %w", m.Version, m.Description, err)
+			return
+		}
+		applied = append(applied, m)
+	}
+
+	return
+}
+
+func (conn *Database) applyMigration(m Migration) (err error) {
+	tx, err := conn.db.Begin()
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	for _, statement := range m.up(conn.dialect) {
+		if _, err = tx.Exec(statement); err != nil {
+			return
+		}
+	}
+
+	if _, err = tx.Exec(`INSERT INTO schema_version (version, description) VALUES ($1, $2)`, m.Version, m.Description); err != nil {
+		return
+	}
+
+	err = tx.Commit()
+	return
+}
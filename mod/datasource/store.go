@@ -0,0 +1,52 @@
+package datasource
+
+type Store interface {
+	Close()
+
+	UpsertMedia(columns []string, valueTable [][]any) error
+	GetMedia() ([]MediaRecord, error)
+	GetMediaByID(id string) (MediaRecord, error)
+	GetMediaByCachePath(patterns []string) ([]MediaRecord, error)
+	GetMediaWithCache() ([]MediaRecord, error)
+	GetCatalog(date string) ([]MediaRecord, error)
+	GetCatalogIndex(minSize uint64) ([]string, error)
+	GetHashCluster(options HashClusterOptions) ([][]HashClusterMember, error)
+	GetSimilarMedia(contentHash uint64, maxDistance int, limit int) ([]SimilarMedia, error)
+	GetThumbnailByID(id string) ([]byte, error)
+	GetThumbnailKeyByID(id string) (string, error)
+	GetThumbnailKeyBySize(id string, width uint) (string, error)
+	GetLegacyThumbnailMediaIds() ([]string, error)
+	GetPreviewKeyByID(id string) (string, error)
+	GetSpriteKeyByID(id string) (string, error)
+
+	GetNoCacheMedia() ([]map[string]any, error)
+	GetCachedVideoMedia() ([]CachedVideoMedia, error)
+	GetUnhashedMedia() ([]UnhashedMedia, error)
+	GetMediaWithoutPreview() ([]AnimatedMedia, error)
+	GetUnprobedMedia() ([]CachedMedia, error)
+	GetMediaWithoutHash(algorithm string, transform string) ([]UnhashedMedia, error)
+	GetBlobKeys() ([]string, error)
+
+	SetCacheData(mediaId string, contentLength uint64, contentHash uint64, cachePath string) error
+	SetCachePaths(cachePaths map[string]string) error
+	SetThumbnailKey(mediaId string, key string) error
+	SetThumbnailKeyBySize(mediaId string, width uint, key string) error
+	SetPreviewKey(mediaId string, key string) error
+	SetSpriteKey(mediaId string, key string) error
+	SetPreviewFailed(mediaId string) error
+	SetSpriteFailed(mediaId string) error
+	SetContentHashData(mediaId string, contentHash uint64) error
+	SetMediaHash(mediaId string, algorithm string, transform string, hash string) error
+	SetMimeType(mediaId string, mimeType string) error
+	SetMediaMetadata(mediaId string, width uint, height uint, durationMillis uint, codec string, bitrate uint64, frameRate float64) error
+	RecordDownloadFailure(mediaId string, lastError string, permanent bool) error
+	RequeueMedia(mediaId string) error
+
+	DeleteMediaAll() error
+	DeleteMediaCached() error
+	DeleteMedia(id string) error
+	DeleteCacheFile(id string) error
+}
+
+var _ Store = (*Database)(nil)
+var _ Store = (*MemoryStore)(nil)
@@ -0,0 +1,312 @@
+package datasource
+
+import (
+	"database/sql"
+	"os"
+	"sort"
+	"testing"
+	"time"
+)
+
+// Every Store implementation runs the same cases. Set TWX_TEST_POSTGRES_URL
+// to a scratch database to include Postgres; its tables are dropped first.
+const postgresTestUrlEnv = "TWX_TEST_POSTGRES_URL"
+
+type storeFactory struct {
+	name string
+	open func(t *testing.T) Store
+}
+
+func storeFactories() []storeFactory {
+	factories := []storeFactory{
+		{"memory", func(t *testing.T) Store { return NewMemoryStore() }},
+		{"sqlite", func(t *testing.T) Store {
+			// Every connection to :memory: opens its own database.
+			return openTestDatabase(t, ConnectConfig{Driver: DriverSQLite, Dbname: ":memory:", MaxOpenConns: 1})
+		}},
+	}
+
+	if url := os.Getenv(postgresTestUrlEnv); url != "" {
+		factories = append(factories, storeFactory{"postgres", func(t *testing.T) Store {
+			return openTestDatabase(t, ConnectConfig{Driver: DriverPostgres, Url: url})
+		}})
+	}
+
+	return factories
+}
+
+func openTestDatabase(t *testing.T, config ConnectConfig) *Database {
+	conn, err := Open(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(conn.Close)
+
+	if config.Driver == DriverPostgres {
+		if _, err = conn.db.Exec(`DROP TABLE IF EXISTS media, thumbnails, media_hashes, hash_generation, schema_version`); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = conn.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+// catalogTimestamp is shortly after local midnight, so a date computed in UTC
+// instead of local time lands on another day in most time zones.
+var catalogTimestamp = uint64(time.Date(2023, 11, 14, 0, 30, 0, 0, time.Local).UnixMilli())
+
+func upsertTestMedia(t *testing.T, store Store, ids ...string) {
+	valueTable := [][]any{}
+	for i, id := range ids {
+		valueTable = append(valueTable, []any{id, "https://x.com/p/" + id, "photo", "https://pbs.twimg.com/" + id + ".jpg", catalogTimestamp + uint64(i)})
+	}
+	err := store.UpsertMedia([]string{"media_id", "parent_url", "type", "url", "timestamp"}, valueTable)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// clearContentHash leaves a cached row without a content hash, as rows cached
+// before the hash was stored are.
+func clearContentHash(t *testing.T, store Store, mediaId string) {
+	switch s := store.(type) {
+	case *MemoryStore:
+		s.rows[mediaId].ContentHash = sql.NullInt64{}
+		s.indexHash(s.rows[mediaId])
+	case *Database:
+		if _, err := s.db.Exec(`UPDATE media SET content_hash=NULL WHERE media_id=$1`, mediaId); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func mediaIds(records []MediaRecord) (ids []string) {
+	for _, r := range records {
+		ids = append(ids, r.MediaId)
+	}
+	sort.Strings(ids)
+	return
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+var storeTests = []struct {
+	name string
+	run  func(t *testing.T, store Store)
+}{
+	{"placeholders bind out of order", func(t *testing.T, store Store) {
+		upsertTestMedia(t, store, "a")
+		// media_id is $1 but comes last in the statement.
+		if err := store.SetCacheData("a", 10, 0xff, "1/a.jpg"); err != nil {
+			t.Fatal(err)
+		}
+
+		r, err := store.GetMediaByID("a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.ContentLength.Int64 != 10 || r.ContentHash.Int64 != 0xff || r.CachePath.String != "1/a.jpg" {
+			t.Errorf("got %v", r)
+		}
+	}},
+	{"missing media", func(t *testing.T, store Store) {
+		if _, err := store.GetMediaByID("missing"); err != sql.ErrNoRows {
+			t.Errorf("got %v, want sql.ErrNoRows", err)
+		}
+	}},
+	{"upsert keeps existing rows", func(t *testing.T, store Store) {
+		upsertTestMedia(t, store, "a")
+		err := store.UpsertMedia([]string{"media_id", "parent_url", "type", "url"}, [][]any{{"a", "changed", "photo", "changed"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r, err := store.GetMediaByID("a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.ParentUrl != "https://x.com/p/a" {
+			t.Errorf("got parent url %s", r.ParentUrl)
+		}
+	}},
+	{"catalog dates in local time", func(t *testing.T, store Store) {
+		upsertTestMedia(t, store, "a", "b")
+		store.SetCacheData("a", 10, 0xff, "1/a.jpg")
+
+		dates, err := store.GetCatalogIndex(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !equalStrings(dates, []string{"2023-11-14"}) {
+			t.Errorf("got dates %v", dates)
+		}
+
+		catalog, err := store.GetCatalog("2023-11-14")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ids := mediaIds(catalog); !equalStrings(ids, []string{"a"}) {
+			t.Errorf("got catalog %v", ids)
+		}
+	}},
+	{"media by cache path", func(t *testing.T, store Store) {
+		upsertTestMedia(t, store, "a", "b")
+		store.SetCacheData("a", 10, 0xff, "1/a.jpg")
+		store.SetCacheData("b", 10, 0xfe, "1/b.mp4")
+
+		records, err := store.GetMediaByCachePath([]string{"b.mp4"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ids := mediaIds(records); !equalStrings(ids, []string{"b"}) {
+			t.Errorf("got %v", ids)
+		}
+	}},
+	{"media by cache path wildcards", func(t *testing.T, store Store) {
+		upsertTestMedia(t, store, "a", "b", "c")
+		store.SetCacheData("a", 10, 0xff, "1/a.jpg")
+		store.SetCacheData("b", 10, 0xfe, "1/b.mp4")
+		store.SetCacheData("c", 10, 0xfd, "2/c.jpg")
+
+		for _, test := range []struct {
+			patterns []string
+			want     []string
+		}{
+			{[]string{"_.jpg"}, []string{"a", "c"}},
+			{[]string{"1/%.jpg"}, []string{"a"}},
+			{[]string{"1/_.mp4", "2/%"}, []string{"b", "c"}},
+			{[]string{"1/__.jpg"}, nil},
+			{[]string{"a.%"}, []string{"a"}},
+		} {
+			records, err := store.GetMediaByCachePath(test.patterns)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ids := mediaIds(records); !equalStrings(ids, test.want) {
+				t.Errorf("%v: got %v, want %v", test.patterns, ids, test.want)
+			}
+		}
+	}},
+	{"updates touch only the given media", func(t *testing.T, store Store) {
+		upsertTestMedia(t, store, "a", "b")
+		store.SetCacheData("a", 10, 0xff, "1/a.jpg")
+		store.SetCacheData("b", 10, 0xfe, "1/b.jpg")
+		store.SetMimeType("a", "image/jpeg")
+		store.SetMimeType("missing", "image/png")
+		store.SetCachePaths(map[string]string{"b": "2/b.jpg", "missing": "2/missing.jpg"})
+		store.DeleteMedia("a")
+
+		a, err := store.GetMediaByID("a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := store.GetMediaByID("b")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a.MimeType.String != "image/jpeg" || a.CachePath.String != "1/a.jpg" || !a.Removed {
+			t.Errorf("got %v", a)
+		}
+		if b.MimeType.Valid || b.CachePath.String != "2/b.jpg" || b.Removed {
+			t.Errorf("got %v", b)
+		}
+		if _, err = store.GetMediaByID("missing"); err != sql.ErrNoRows {
+			t.Errorf("got %v, want sql.ErrNoRows", err)
+		}
+	}},
+	{"hash cluster", func(t *testing.T, store Store) {
+		upsertTestMedia(t, store, "a", "b", "c")
+		store.SetCacheData("a", 20, 0xff, "1/a.jpg")
+		store.SetCacheData("b", 10, 0xfe, "1/b.jpg")
+		store.SetCacheData("c", 10, 0x123456789abcdef0, "1/c.jpg")
+
+		clusters, err := store.GetHashCluster(NewHashClusterOptions())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(clusters) != 1 || len(clusters[0]) != 2 {
+			t.Fatalf("got %v", clusters)
+		}
+		if clusters[0][0].MediaId != "a" || clusters[0][1].MediaId != "b" || clusters[0][1].Distance != 1 {
+			t.Errorf("got %v", clusters[0])
+		}
+
+		store.DeleteCacheFile("b")
+		clusters, err = store.GetHashCluster(NewHashClusterOptions())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(clusters) != 0 {
+			t.Errorf("got %v after deleting the cache file", clusters)
+		}
+	}},
+	{"unhashed media", func(t *testing.T, store Store) {
+		upsertTestMedia(t, store, "a", "b", "c")
+		store.SetCacheData("a", 10, 0xff, "1/a.jpg")
+		store.SetCacheData("b", 10, 0xfe, "1/b.jpg")
+		store.SetThumbnailKey("a", "ka")
+		store.SetThumbnailKey("b", "kb")
+		clearContentHash(t, store, "b")
+
+		unhashed, err := store.GetUnhashedMedia()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(unhashed) != 1 || unhashed[0].MediaId != "b" || unhashed[0].ThumbnailKey != "kb" {
+			t.Errorf("got %v", unhashed)
+		}
+	}},
+	{"media without hash", func(t *testing.T, store Store) {
+		upsertTestMedia(t, store, "a", "b")
+		store.SetCacheData("a", 10, 0xff, "1/a.jpg")
+		store.SetCacheData("b", 10, 0xfe, "1/b.jpg")
+		store.SetThumbnailKey("a", "ka")
+		store.SetThumbnailKey("b", "kb")
+		store.SetMediaHash("a", "phash", "", "00000000000000ff")
+
+		unhashed, err := store.GetMediaWithoutHash("phash", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(unhashed) != 1 || unhashed[0].MediaId != "b" || unhashed[0].ThumbnailKey != "kb" {
+			t.Errorf("got %v", unhashed)
+		}
+	}},
+	{"blob keys", func(t *testing.T, store Store) {
+		upsertTestMedia(t, store, "a")
+		store.SetThumbnailKey("a", "k1")
+		store.SetThumbnailKeyBySize("a", 320, "k2")
+		store.SetPreviewKey("a", "k3")
+
+		keys, err := store.GetBlobKeys()
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(keys)
+		if !equalStrings(keys, []string{"k1", "k2", "k3"}) {
+			t.Errorf("got %v", keys)
+		}
+	}},
+}
+
+func TestStoreConformance(t *testing.T) {
+	for _, factory := range storeFactories() {
+		for _, test := range storeTests {
+			t.Run(factory.name+"/"+test.name, func(t *testing.T) {
+				test.run(t, factory.open(t))
+			})
+		}
+	}
+}
@@ -0,0 +1,418 @@
+package diffhash
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"math/bits"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	AlgorithmDiffHash          = "dhash"
+	AlgorithmDiffHash256       = "dhash256"
+	AlgorithmAverageHash       = "ahash"
+	AlgorithmAverageHash256    = "ahash256"
+	AlgorithmPerceptualHash    = "phash"
+	AlgorithmPerceptualHash256 = "phash256"
+	AlgorithmWaveletHash       = "whash"
+	AlgorithmWaveletHash256    = "whash256"
+)
+
+type Hash struct {
+	Algorithm string
+	Transform string
+	Bits      []uint64
+}
+
+func (h Hash) IsZero() bool {
+	for _, w := range h.Bits {
+		if w != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (h Hash) String() string {
+	var b strings.Builder
+	for _, w := range h.Bits {
+		fmt.Fprintf(&b, "%016x", w)
+	}
+	return b.String()
+}
+
+func ParseHash(algorithm string, s string) (h Hash, err error) {
+	if len(s) == 0 || len(s)%16 != 0 {
+		err = fmt.Errorf("invalid hash: %s", s)
+		return
+	}
+
+	h.Algorithm = algorithm
+	for i := 0; i < len(s); i += 16 {
+		var w uint64
+		w, err = strconv.ParseUint(s[i:i+16], 16, 64)
+		if err != nil {
+			return
+		}
+		h.Bits = append(h.Bits, w)
+	}
+	return
+}
+
+func CompHash(a Hash, b Hash) (distance int, err error) {
+	if a.Algorithm != b.Algorithm || len(a.Bits) != len(b.Bits) {
+		err = fmt.Errorf("cannot compare %s hash with %s hash", a.Algorithm, b.Algorithm)
+		return
+	}
+
+	for i := range a.Bits {
+		distance += bits.OnesCount64(a.Bits[i] ^ b.Bits[i])
+	}
+	return
+}
+
+type Hasher interface {
+	Algorithm() string
+	Hash(img image.Image) Hash
+}
+
+var hashers = map[string]Hasher{
+	AlgorithmDiffHash:          diffHasher{8},
+	AlgorithmDiffHash256:       diffHasher{16},
+	AlgorithmAverageHash:       averageHasher{8},
+	AlgorithmAverageHash256:    averageHasher{16},
+	AlgorithmPerceptualHash:    perceptualHasher{8},
+	AlgorithmPerceptualHash256: perceptualHasher{16},
+	AlgorithmWaveletHash:       waveletHasher{8},
+	AlgorithmWaveletHash256:    waveletHasher{16},
+}
+
+func NewHasher(algorithm string) (Hasher, error) {
+	hasher, ok := hashers[algorithm]
+	if !ok {
+		return nil, fmt.Errorf("unknown hash algorithm: %s", algorithm)
+	}
+	return hasher, nil
+}
+
+func Algorithms() []string {
+	algorithms := []string{}
+	for algorithm := range hashers {
+		algorithms = append(algorithms, algorithm)
+	}
+	sort.Strings(algorithms)
+	return algorithms
+}
+
+func CalcHashFromImage(hasher Hasher, inputImage []byte) (h Hash, err error) {
+	img, err := decodeImage(inputImage)
+	if err != nil {
+		return
+	}
+
+	return hasher.Hash(img), nil
+}
+
+func CalcHashesFromImage(hashers []Hasher, transforms []string, inputImage []byte) (hashes []Hash, err error) {
+	img, err := decodeImage(inputImage)
+	if err != nil {
+		return
+	}
+
+	for _, transform := range transforms {
+		transformed, err := TransformImage(img, transform)
+		if err != nil {
+			return nil, err
+		}
+		for _, hasher := range hashers {
+			h := hasher.Hash(transformed)
+			h.Transform = transform
+			hashes = append(hashes, h)
+		}
+	}
+	return
+}
+
+func CalcHashFromFile(hasher Hasher, inputName string) (h Hash, err error) {
+	img, err := openImage(inputName)
+	if err != nil {
+		return
+	}
+
+	return hasher.Hash(img), nil
+}
+
+func luminance(img image.Image, width, height int) []float64 {
+	bounds := img.Bounds()
+	srcWidth, srcHeight := bounds.Dx(), bounds.Dy()
+	pix := make([]float64, width*height)
+	if srcWidth == 0 || srcHeight == 0 {
+		return pix
+	}
+
+	for y := 0; y < height; y++ {
+		y0 := y * srcHeight / height
+		y1 := max((y+1)*srcHeight/height, y0+1)
+		for x := 0; x < width; x++ {
+			x0 := x * srcWidth / width
+			x1 := max((x+1)*srcWidth/width, x0+1)
+
+			sum := 0.0
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					r, g, b, _ := img.At(bounds.Min.X+sx, bounds.Min.Y+sy).RGBA()
+					sum += (0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)) / 257
+				}
+			}
+			pix[y*width+x] = sum / float64((y1-y0)*(x1-x0))
+		}
+	}
+
+	return pix
+}
+
+func isFlat(values []float64) bool {
+	for _, v := range values[1:] {
+		if math.Abs(v-values[0]) > 1 {
+			return false
+		}
+	}
+	return true
+}
+
+func median(values []float64) float64 {
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+	n := len(sorted)
+	if n%2 == 0 {
+		return (sorted[n/2-1] + sorted[n/2]) / 2
+	}
+	return sorted[n/2]
+}
+
+func hashFromBits(algorithm string, set []bool) Hash {
+	h := Hash{Algorithm: algorithm, Bits: make([]uint64, (len(set)+63)/64)}
+	for i, on := range set {
+		if on {
+			h.Bits[i/64] |= 1 << (63 - i%64)
+		}
+	}
+	return h
+}
+
+func thresholdHash(algorithm string, values []float64, threshold float64) Hash {
+	set := make([]bool, len(values))
+	for i, v := range values {
+		set[i] = v > threshold
+	}
+	return hashFromBits(algorithm, set)
+}
+
+type diffHasher struct {
+	size int
+}
+
+func (d diffHasher) Algorithm() string {
+	if d.size == 8 {
+		return AlgorithmDiffHash
+	}
+	return AlgorithmDiffHash256
+}
+
+func (d diffHasher) Hash(img image.Image) Hash {
+	if d.size == 8 {
+		return Hash{Algorithm: AlgorithmDiffHash, Bits: []uint64{computeImageDiffHash(img)}}
+	}
+
+	width := d.size + 1
+	pix := luminance(img, width, d.size)
+	if isFlat(pix) {
+		return Hash{Algorithm: d.Algorithm(), Bits: make([]uint64, d.size*d.size/64)}
+	}
+
+	set := make([]bool, 0, d.size*d.size)
+	for y := 0; y < d.size; y++ {
+		for x := 0; x < d.size; x++ {
+			set = append(set, pix[y*width+x] > pix[y*width+x+1])
+		}
+	}
+	return hashFromBits(d.Algorithm(), set)
+}
+
+type averageHasher struct {
+	size int
+}
+
+func (a averageHasher) Algorithm() string {
+	if a.size == 8 {
+		return AlgorithmAverageHash
+	}
+	return AlgorithmAverageHash256
+}
+
+func (a averageHasher) Hash(img image.Image) Hash {
+	pix := luminance(img, a.size, a.size)
+	if isFlat(pix) {
+		return Hash{Algorithm: a.Algorithm(), Bits: make([]uint64, a.size*a.size/64)}
+	}
+
+	mean := 0.0
+	for _, v := range pix {
+		mean += v
+	}
+	mean /= float64(len(pix))
+
+	return thresholdHash(a.Algorithm(), pix, mean)
+}
+
+type perceptualHasher struct {
+	size int
+}
+
+func (p perceptualHasher) Algorithm() string {
+	if p.size == 8 {
+		return AlgorithmPerceptualHash
+	}
+	return AlgorithmPerceptualHash256
+}
+
+func (p perceptualHasher) Hash(img image.Image) Hash {
+	n := p.size * 4
+	pix := luminance(img, n, n)
+	if isFlat(pix) {
+		return Hash{Algorithm: p.Algorithm(), Bits: make([]uint64, p.size*p.size/64)}
+	}
+
+	coefficients := dct2(pix, n)
+	low := make([]float64, 0, p.size*p.size)
+	for y := 0; y < p.size; y++ {
+		low = append(low, coefficients[y*n:y*n+p.size]...)
+	}
+
+	return thresholdHash(p.Algorithm(), low, median(low))
+}
+
+func dct2(pix []float64, n int) []float64 {
+	cosines := make([]float64, n*n)
+	for k := 0; k < n; k++ {
+		for i := 0; i < n; i++ {
+			cosines[k*n+i] = math.Cos(math.Pi / float64(n) * (float64(i) + 0.5) * float64(k))
+		}
+	}
+
+	rows := make([]float64, n*n)
+	for y := 0; y < n; y++ {
+		for k := 0; k < n; k++ {
+			sum := 0.0
+			for x := 0; x < n; x++ {
+				sum += pix[y*n+x] * cosines[k*n+x]
+			}
+			rows[y*n+k] = sum
+		}
+	}
+
+	out := make([]float64, n*n)
+	for x := 0; x < n; x++ {
+		for k := 0; k < n; k++ {
+			sum := 0.0
+			for y := 0; y < n; y++ {
+				sum += rows[y*n+x] * cosines[k*n+y]
+			}
+			out[k*n+x] = sum
+		}
+	}
+
+	return out
+}
+
+type waveletHasher struct {
+	size int
+}
+
+func (w waveletHasher) Algorithm() string {
+	if w.size == 8 {
+		return AlgorithmWaveletHash
+	}
+	return AlgorithmWaveletHash256
+}
+
+func (w waveletHasher) Hash(img image.Image) Hash {
+	n := w.size * 8
+	pix := luminance(img, n, n)
+	if isFlat(pix) {
+		return Hash{Algorithm: w.Algorithm(), Bits: make([]uint64, w.size*w.size/64)}
+	}
+
+	// Like imagehash's remove_max_haar_ll, decompose down to the single DC
+	// coefficient, drop it and rebuild the image before hashing.
+	for m := n; m > 1; m /= 2 {
+		haarStep(pix, n, m)
+	}
+	pix[0] = 0
+	for m := 2; m <= n; m *= 2 {
+		inverseHaarStep(pix, n, m)
+	}
+
+	for m := n; m > w.size; m /= 2 {
+		haarStep(pix, n, m)
+	}
+	low := make([]float64, 0, w.size*w.size)
+	for y := 0; y < w.size; y++ {
+		low = append(low, pix[y*n:y*n+w.size]...)
+	}
+
+	return thresholdHash(w.Algorithm(), low, median(low))
+}
+
+// haarStep applies one level of the orthonormal 2D Haar transform to the
+// top-left m×m block of pix, leaving the approximation (LL) band in its
+// top-left quarter and the detail bands in the other three.
+func haarStep(pix []float64, stride int, m int) {
+	half := m / 2
+	tmp := make([]float64, m)
+	for y := 0; y < m; y++ {
+		row := pix[y*stride : y*stride+m]
+		for x := 0; x < half; x++ {
+			tmp[x] = (row[2*x] + row[2*x+1]) / math.Sqrt2
+			tmp[half+x] = (row[2*x] - row[2*x+1]) / math.Sqrt2
+		}
+		copy(row, tmp)
+	}
+	for x := 0; x < m; x++ {
+		for y := 0; y < half; y++ {
+			a, b := pix[2*y*stride+x], pix[(2*y+1)*stride+x]
+			tmp[y] = (a + b) / math.Sqrt2
+			tmp[half+y] = (a - b) / math.Sqrt2
+		}
+		for y := 0; y < m; y++ {
+			pix[y*stride+x] = tmp[y]
+		}
+	}
+}
+
+func inverseHaarStep(pix []float64, stride int, m int) {
+	half := m / 2
+	tmp := make([]float64, m)
+	for x := 0; x < m; x++ {
+		for y := 0; y < half; y++ {
+			a, d := pix[y*stride+x], pix[(half+y)*stride+x]
+			tmp[2*y] = (a + d) / math.Sqrt2
+			tmp[2*y+1] = (a - d) / math.Sqrt2
+		}
+		for y := 0; y < m; y++ {
+			pix[y*stride+x] = tmp[y]
+		}
+	}
+	for y := 0; y < m; y++ {
+		row := pix[y*stride : y*stride+m]
+		for x := 0; x < half; x++ {
+			a, d := row[x], row[half+x]
+			tmp[2*x] = (a + d) / math.Sqrt2
+			tmp[2*x+1] = (a - d) / math.Sqrt2
+		}
+		copy(row, tmp)
+	}
+}
@@ -0,0 +1,87 @@
+package diffhash
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// testImage draws a disc and a bar over a dithered diagonal gradient, so no
+// two blocks tie at the median; brightness is added to every pixel.
+func testImage(brightness int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 128, 128))
+	for y := 0; y < 128; y++ {
+		for x := 0; x < 128; x++ {
+			v := 40 + (x+2*y)/3 + (x*31+y*17)%7
+			if (x-80)*(x-80)+(y-48)*(y-48) < 24*24 {
+				v += 80
+			}
+			if x >= 16 && x < 48 && y >= 72 && y < 112 {
+				v -= 30
+			}
+			v += brightness
+			img.Set(x, y, color.RGBA{uint8(v), uint8(v), uint8(v), 0xff})
+		}
+	}
+	return img
+}
+
+var hasherTests = []struct {
+	algorithm string
+	words     int
+	want      string
+}{
+	{AlgorithmDiffHash, 1, "000c06070d010000"},
+	{AlgorithmDiffHash256, 4, "0000000000000038000c000c000c000c00386000600060006000600000000000"},
+	{AlgorithmAverageHash, 1, "00041e1f0f1f9fff"},
+	{AlgorithmAverageHash256, 4, "00000000000000f001f801f901ff01ff00ff01ff03ff03ff43ffc3ffffffffff"},
+	{AlgorithmPerceptualHash, 1, "9a13656fc39c8c1b"},
+	{AlgorithmPerceptualHash256, 4, "9a90139c656b6f63c19c9c8c8c8c1b53931b939b6c67636f3c956c9c6ca4d31b"},
+	{AlgorithmWaveletHash, 1, "00040e1f0f1f9fff"},
+	{AlgorithmWaveletHash256, 4, "00000000000000f001f801f901ff01ff00ff03ff03ff03ff43ffc3ffffffffff"},
+}
+
+func TestHashers(t *testing.T) {
+	img := testImage(0)
+	brighter := testImage(12)
+
+	for _, test := range hasherTests {
+		t.Run(test.algorithm, func(t *testing.T) {
+			hasher, err := NewHasher(test.algorithm)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			h := hasher.Hash(img)
+			if h.Algorithm != test.algorithm {
+				t.Errorf("got algorithm %s", h.Algorithm)
+			}
+			if len(h.Bits) != test.words {
+				t.Errorf("got %d words, want %d", len(h.Bits), test.words)
+			}
+			if h.String() != test.want {
+				t.Errorf("got %s, want %s", h, test.want)
+			}
+
+			d, err := CompHash(h, hasher.Hash(brighter))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d != 0 {
+				t.Errorf("brightness changed the hash by %d bits", d)
+			}
+		})
+	}
+}
+
+func TestHashersCoverAlgorithms(t *testing.T) {
+	tested := map[string]bool{}
+	for _, test := range hasherTests {
+		tested[test.algorithm] = true
+	}
+	for _, algorithm := range Algorithms() {
+		if !tested[algorithm] {
+			t.Errorf("no test for %s", algorithm)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package diffhash
+
+import (
+	"fmt"
+	"image"
+)
+
+const (
+	TransformNone      = ""
+	TransformFlip      = "flip"
+	TransformRotate90  = "rot90"
+	TransformRotate180 = "rot180"
+	TransformRotate270 = "rot270"
+)
+
+func Transforms() []string {
+	return []string{TransformFlip, TransformRotate90, TransformRotate180, TransformRotate270}
+}
+
+// TransformImage mirrors the image horizontally or rotates it clockwise.
+func TransformImage(img image.Image, transform string) (image.Image, error) {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	var source func(x, y int) (int, int)
+	switch transform {
+	case TransformNone:
+		return img, nil
+	case TransformFlip:
+		source = func(x, y int) (int, int) { return width - 1 - x, y }
+	case TransformRotate90:
+		width, height = height, width
+		source = func(x, y int) (int, int) { return y, width - 1 - x }
+	case TransformRotate180:
+		source = func(x, y int) (int, int) { return width - 1 - x, height - 1 - y }
+	case TransformRotate270:
+		width, height = height, width
+		source = func(x, y int) (int, int) { return height - 1 - y, x }
+	default:
+		return nil, fmt.Errorf("unknown transform: %s", transform)
+	}
+
+	transformed := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			sx, sy := source(x, y)
+			transformed.Set(x, y, img.At(bounds.Min.X+sx, bounds.Min.Y+sy))
+		}
+	}
+
+	return transformed, nil
+}
@@ -0,0 +1,343 @@
+package mediadata
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const TempFileSuffix = ".part"
+
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	MaxRetryAfter  time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    4,
+	InitialBackoff: time.Second,
+	MaxBackoff:     30 * time.Second,
+	Multiplier:     2,
+	MaxRetryAfter:  2 * time.Minute,
+}
+
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	delay := float64(p.InitialBackoff) * math.Pow(p.Multiplier, float64(attempt-1))
+	if delay > float64(p.MaxBackoff) {
+		delay = float64(p.MaxBackoff)
+	}
+
+	half := delay / 2
+	return time.Duration(half + rand.Float64()*half)
+}
+
+const DefaultMaxContentLength int64 = 2 << 30
+
+type Downloader struct {
+	Client           *http.Client
+	Retry            RetryPolicy
+	MaxContentLength int64
+}
+
+func (d *Downloader) maxContentLength() int64 {
+	if d.MaxContentLength > 0 {
+		return d.MaxContentLength
+	}
+	return DefaultMaxContentLength
+}
+
+func newDefaultClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = 30 * time.Second
+	transport.MaxIdleConnsPerHost = 8
+	return &http.Client{Transport: transport}
+}
+
+var DefaultDownloader = &Downloader{
+	Client:           newDefaultClient(),
+	Retry:            DefaultRetryPolicy,
+	MaxContentLength: DefaultMaxContentLength,
+}
+
+func (d *Downloader) DownloadFile(ctx context.Context, baseDir string, targetUrl string) (cacheData CacheData, err error) {
+	maxAttempts := d.Retry.MaxAttempts
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+
+	for attempt := 1; ; attempt++ {
+		cacheData, err = d.downloadOnce(ctx, baseDir, targetUrl)
+		// Local failures are left to the next run instead of retried here.
+		var merr *MediaError
+		if err == nil || ctx.Err() != nil || !errors.As(err, &merr) || !merr.IsTransient() || attempt >= maxAttempts {
+			return
+		}
+
+		wait := d.Retry.backoff(attempt)
+		if merr.RetryAfter() > wait {
+			if merr.RetryAfter() > d.Retry.MaxRetryAfter {
+				return
+			}
+			wait = merr.RetryAfter()
+		}
+
+		log.Printf("Retry %d/%d in %s: %s\n", attempt, maxAttempts-1, wait.Round(time.Millisecond), err)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			err = ctx.Err()
+			return
+		}
+	}
+}
+
+func (d *Downloader) downloadOnce(ctx context.Context, baseDir string, targetUrl string) (cacheData CacheData, err error) {
+	log.Println("URL: " + targetUrl)
+	u, err := url.Parse(targetUrl)
+	if err != nil {
+		err = MediaBadRequestError("invalid url: %s", err)
+		return
+	}
+
+	pathSegments := strings.Split(u.Path, "/")
+	filename := pathSegments[len(pathSegments)-1]
+
+	outputPath := path.Join(baseDir, filename)
+	tempPath := outputPath + TempFileSuffix
+
+	var offset int64
+	if info, statErr := os.Stat(tempPath); statErr == nil {
+		offset = info.Size()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, targetUrl, nil)
+	if err != nil {
+		err = MediaBadRequestError("invalid request: %s", err)
+		return
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	res, err := d.Client.Do(req)
+	if err != nil {
+		if ctx.Err() == nil {
+			err = MediaTransientError(0, 0, "request failed: %s", err)
+		}
+		return
+	}
+	defer res.Body.Close()
+
+	log.Println("Status code: " + strconv.Itoa(res.StatusCode))
+
+	if res.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		os.Remove(tempPath)
+		err = MediaTransientError(res.StatusCode, 0, "discarded partial download: %s", res.Status)
+		return
+	}
+
+	if err = checkStatus(res); err != nil {
+		return
+	}
+
+	contentTypes := res.Header.Values("Content-Type")
+	if len(contentTypes) == 0 {
+		err = MediaBadGatewayError("no Content-type is obtained")
+		return
+	}
+	log.Println("Content-Type: " + strings.Join(contentTypes, "; "))
+
+	if strings.HasPrefix(contentTypes[0], "text/") {
+		err = MediaBadGatewayError("Not cached: unsupport content-type")
+		return
+	}
+
+	length := res.ContentLength
+	if length >= 0 {
+		log.Println("Content-Length: " + strconv.FormatInt(length, 10))
+	} else {
+		log.Println("Content-Length: unknown")
+	}
+
+	flag := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if res.StatusCode == http.StatusPartialContent {
+		start, ok := parseContentRangeStart(res.Header.Get("Content-Range"))
+		if !ok || start != offset {
+			os.Remove(tempPath)
+			err = MediaTransientError(res.StatusCode, 0, "unexpected Content-Range: %s", res.Header.Get("Content-Range"))
+			return
+		}
+		log.Printf("Resume: %d bytes\n", offset)
+		flag = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	} else {
+		offset = 0
+	}
+
+	maxContentLength := d.maxContentLength()
+	if length == 0 && offset == 0 {
+		err = MediaNoContentError("Not cached: content-length is zero")
+		return
+	}
+	if length > 0 && offset+length > maxContentLength {
+		err = MediaStatusError(http.StatusRequestEntityTooLarge, "Not cached: content-length %d exceeds %d", offset+length, maxContentLength)
+		return
+	}
+
+	log.Println("Output: " + tempPath)
+	out, err := os.OpenFile(tempPath, flag, 0666)
+	if err != nil {
+		return
+	}
+	defer out.Close()
+
+	writtenSize, err := io.Copy(out, io.LimitReader(res.Body, maxContentLength-offset+1))
+	if err != nil {
+		if ctx.Err() == nil {
+			err = MediaTransientError(0, 0, "download interrupted: %s", err)
+		}
+		return
+	}
+
+	size := offset + writtenSize
+	if size > maxContentLength {
+		os.Remove(tempPath)
+		err = MediaStatusError(http.StatusRequestEntityTooLarge, "Not cached: content exceeds %d", maxContentLength)
+		return
+	}
+
+	if length >= 0 && writtenSize != length {
+		if writtenSize > length {
+			os.Remove(tempPath)
+		}
+		err = MediaTransientError(0, 0, "download error: %d/%d", size, offset+length)
+		return
+	}
+
+	if size == 0 {
+		os.Remove(tempPath)
+		err = MediaNoContentError("Not cached: content is empty")
+		return
+	}
+
+	if err = out.Sync(); err != nil {
+		return
+	}
+
+	mimeType, err := DetectMimeTypeFromFile(tempPath)
+	if err != nil {
+		return
+	}
+	log.Println("Detected: " + mimeType)
+	if strings.HasPrefix(mimeType, "text/") {
+		os.Remove(tempPath)
+		err = MediaBadGatewayError("Not cached: unexpected content: %s", mimeType)
+		return
+	}
+	outputPath = path.Join(baseDir, canonicalFilename(filename, mimeType))
+
+	if err = out.Close(); err != nil {
+		return
+	}
+
+	err = os.Chmod(tempPath, 0666)
+	if err != nil {
+		return
+	}
+
+	if err = os.Rename(tempPath, outputPath); err != nil {
+		return
+	}
+	log.Println("Output: " + outputPath)
+
+	cacheData.ContentLength = uint64(size)
+	cacheData.CachePath = outputPath
+	cacheData.MimeType = mimeType
+
+	log.Println("Complete")
+	return
+}
+
+func parseContentRangeStart(contentRange string) (start int64, ok bool) {
+	var end int64
+	if _, err := fmt.Sscanf(contentRange, "bytes %d-%d/", &start, &end); err != nil {
+		return 0, false
+	}
+	return start, true
+}
+
+func CleanupTempFiles(root string, maxAge time.Duration) (removed int, err error) {
+	err = filepath.WalkDir(root, func(p string, entry fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			if os.IsNotExist(walkErr) {
+				return nil
+			}
+			return walkErr
+		}
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), TempFileSuffix) {
+			return nil
+		}
+
+		info, infoErr := entry.Info()
+		if infoErr != nil || time.Since(info.ModTime()) < maxAge {
+			return nil
+		}
+
+		if removeErr := os.Remove(p); removeErr != nil && !os.IsNotExist(removeErr) {
+			return removeErr
+		}
+		log.Println("Removed temp file: " + p)
+		removed++
+		return nil
+	})
+	return
+}
+
+func checkStatus(res *http.Response) error {
+	code := res.StatusCode
+	switch {
+	case code >= 200 && code < 300:
+		return nil
+	case code == http.StatusNotFound || code == http.StatusGone:
+		return MediaNotFoundError("not found content")
+	case code == http.StatusTooManyRequests || code == http.StatusRequestTimeout || code >= 500:
+		return MediaTransientError(code, parseRetryAfter(res.Header.Get("Retry-After")), "server responded: %s", res.Status)
+	}
+	return MediaStatusError(code, "server responded: %s", res.Status)
+}
+
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(date); wait > 0 {
+			return wait
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,128 @@
+package mediadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/draw"
+)
+
+const exifOrientationTag = 0x0112
+
+func readExifOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return 1
+	}
+
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xff {
+			return 1
+		}
+		marker := data[i+1]
+		if marker == 0xd8 || (marker >= 0xd0 && marker <= 0xd7) {
+			i += 2
+			continue
+		}
+		if marker == 0xda || marker == 0xd9 {
+			return 1
+		}
+
+		segmentLength := int(binary.BigEndian.Uint16(data[i+2 : i+4]))
+		if segmentLength < 2 || i+2+segmentLength > len(data) {
+			return 1
+		}
+		segment := data[i+4 : i+2+segmentLength]
+
+		if marker == 0xe1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return parseTiffOrientation(segment[6:])
+		}
+
+		i += 2 + segmentLength
+	}
+
+	return 1
+}
+
+func parseTiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifdOffset := int(order.Uint32(tiff[4:8]))
+	if ifdOffset+2 > len(tiff) {
+		return 1
+	}
+
+	entries := int(order.Uint16(tiff[ifdOffset : ifdOffset+2]))
+	for n := 0; n < entries; n++ {
+		entry := ifdOffset + 2 + n*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:entry+2]) != exifOrientationTag {
+			continue
+		}
+
+		orientation := int(order.Uint16(tiff[entry+8 : entry+10]))
+		if orientation < 1 || orientation > 8 {
+			return 1
+		}
+		return orientation
+	}
+
+	return 1
+}
+
+func applyOrientation(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	src := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+
+	dstWidth, dstHeight := width, height
+	if orientation >= 5 {
+		dstWidth, dstHeight = height, width
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2:
+				dx, dy = width-1-x, y
+			case 3:
+				dx, dy = width-1-x, height-1-y
+			case 4:
+				dx, dy = x, height-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = height-1-y, x
+			case 7:
+				dx, dy = height-1-y, width-1-x
+			case 8:
+				dx, dy = y, width-1-x
+			}
+			si := src.PixOffset(x, y)
+			di := dst.PixOffset(dx, dy)
+			copy(dst.Pix[di:di+4], src.Pix[si:si+4])
+		}
+	}
+
+	return dst
+}
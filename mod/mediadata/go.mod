@@ -4,6 +4,7 @@ go 1.21.4
 
 require (
 	diffhash v0.0.0
+	golang.org/x/image v0.20.0
 )
 
 replace diffhash => ../diffhash
@@ -2,19 +2,14 @@ package mediadata
 
 import (
 	"bytes"
+	"context"
 	"diffhash"
 	"encoding/json"
 	"fmt"
-	"io"
-	"log"
-	"net/http"
-	"net/url"
 	"os"
 	"os/exec"
-	"path"
 	"path/filepath"
 	"regexp"
-	"strconv"
 	"strings"
 )
 
@@ -29,19 +24,38 @@ type MediaData struct {
 	DurationMillis uint   `json:"durationMillis,omitempty"`
 	VideoUrl       string `json:"videoUrl,omitempty"`
 	MediaPath      string `json:"mediaPath,omitempty"`
+	FilePath       string `json:"filePath,omitempty"`
 	ThumbPath      string `json:"thumbPath,omitempty"`
+	PreviewPath    string `json:"previewPath,omitempty"`
+	SpritePath     string `json:"spritePath,omitempty"`
 }
 
 type CacheData struct {
 	ContentLength uint64
 	ContentHash   uint64
 	CachePath     string
+	MimeType      string
 	Thumbnail     []byte
 }
 
+func (m *MediaData) IsVideo() bool {
+	return m.Type == "video" || m.Type == "animated_gif"
+}
+
+func (m *MediaData) SourceUrl() string {
+	if m.IsVideo() {
+		return m.VideoUrl
+	}
+	return m.Url
+}
+
 func (m *MediaData) DownloadMedia(baseDir string) (cacheData CacheData, err error) {
-	if m.Type == "video" || m.Type == "animated_gif" {
-		cacheData, err = DownloadFile(baseDir, m.VideoUrl)
+	return m.DownloadMediaContext(context.Background(), baseDir)
+}
+
+func (m *MediaData) DownloadMediaContext(ctx context.Context, baseDir string) (cacheData CacheData, err error) {
+	if m.IsVideo() {
+		cacheData, err = DownloadFileContext(ctx, baseDir, m.VideoUrl)
 		if err != nil {
 			return
 		}
@@ -53,14 +67,14 @@ func (m *MediaData) DownloadMedia(baseDir string) (cacheData CacheData, err erro
 			return
 		}
 
-		cacheData, err = DownloadFile(baseDir, imageUri)
+		cacheData, err = DownloadFileContext(ctx, baseDir, imageUri)
 		if err != nil {
 			return
 		}
 
 	}
 
-	thumbnail, err := MakeThumbnail(cacheData.CachePath, 0)
+	thumbnail, err := MakeThumbnailForType(cacheData.CachePath, m.Type, 0)
 	if err != nil {
 		return
 	}
@@ -105,7 +119,7 @@ func normalizeImageUrl(mediaUrl string) (imageUrl string, err error) {
 	if len(m) < 2 {
 		m = ParseRegexp(`^https://pbs\.twimg\.com/media/(?P<filename>[^\.]+?)\.(?P<extension>.+)$`, mediaUrl)
 		if len(m) < 2 {
-			err = fmt.Errorf("failed parse url: " + mediaUrl)
+			err = MediaBadRequestError("failed parse url: %s", mediaUrl)
 			return
 		}
 	}
@@ -116,91 +130,16 @@ func normalizeImageUrl(mediaUrl string) (imageUrl string, err error) {
 }
 
 func DownloadFile(baseDir string, targetUrl string) (cacheData CacheData, err error) {
-	log.Println("URL: " + targetUrl)
-	u, err := url.Parse(targetUrl)
-	if err != nil {
-		return
-	}
-
-	pathSegments := strings.Split(u.Path, "/")
-	filename := pathSegments[len(pathSegments)-1]
-
-	res, err := http.Get(targetUrl)
-	if err != nil {
-		return
-	}
-	defer res.Body.Close()
-
-	log.Println("Status code: " + strconv.Itoa(res.StatusCode))
-
-	if res.StatusCode == 404 {
-		err = MediaNotFoundError("not found content")
-		return
-	}
-
-	contentTypes := res.Header.Values("Content-Type")
-	if len(contentTypes) == 0 {
-		err = MediaBadGatewayError("no Content-type is obtained")
-		return
-	}
-	log.Println("Content-Type: " + strings.Join(contentTypes, "; "))
-
-	contentLengths := res.Header.Values("Content-Length")
-	if len(contentLengths) == 0 {
-		err = MediaBadGatewayError("no Content-length is obtained")
-		return
-	}
-	log.Println("Content-Length: " + strings.Join(contentLengths, "; "))
-
-	if strings.HasPrefix(contentTypes[0], "text/") {
-		err = MediaBadGatewayError("Not cached: unsupport content-type")
-		return
-	}
-
-	size, err := strconv.ParseUint(contentLengths[0], 10, 64)
-	if err != nil {
-		return
-	}
-
-	if size == 0 {
-		err = MediaNoContentError("Not cached: content-length is zero")
-		return
-	}
-
-	outputPath := path.Join(baseDir, filename)
-
-	log.Println("Output: " + outputPath)
-	out, err := os.Create(outputPath)
-	if err != nil {
-		return
-	}
-	defer out.Close()
-
-	writtenSize, err := io.Copy(out, res.Body)
-	if err != nil {
-		return
-	}
-
-	if uint64(writtenSize) != size {
-		err = MediaInternalServerError("download error: %d/%d", writtenSize, size)
-		return
-	}
-
-	err = os.Chmod(outputPath, 0666)
-	if err != nil {
-		return
-	}
-
-	cacheData.ContentLength = size
-	cacheData.CachePath = outputPath
+	return DownloadFileContext(context.Background(), baseDir, targetUrl)
+}
 
-	log.Println("Complete")
-	return
+func DownloadFileContext(ctx context.Context, baseDir string, targetUrl string) (cacheData CacheData, err error) {
+	return DefaultDownloader.DownloadFile(ctx, baseDir, targetUrl)
 }
 
 func MakeThumbnail(videoPath string, thumbnailWidth uint) (thumbnail []byte, err error) {
 	if thumbnailWidth == 0 {
-		thumbnailWidth = 160
+		thumbnailWidth = DefaultThumbnailWidth
 	}
 
 	vf := fmt.Sprintf("thumbnail,scale=%d:-1", thumbnailWidth)
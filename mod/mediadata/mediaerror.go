@@ -1,21 +1,52 @@
 package mediadata
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"time"
 )
 
 type MediaError struct {
-	code    int
-	message string
+	code       int
+	message    string
+	transient  bool
+	retryAfter time.Duration
 }
 
 func (e *MediaError) Error() string {
 	return e.message
 }
 
+func (e *MediaError) Code() int {
+	return e.code
+}
+
 func (e *MediaError) IsNotFound() bool {
-	return e.code == http.StatusNotFound
+	return e.code == http.StatusNotFound || e.code == http.StatusGone
+}
+
+func (e *MediaError) IsTransient() bool {
+	return e.transient
+}
+
+func (e *MediaError) IsPermanent() bool {
+	return !e.transient
+}
+
+func (e *MediaError) RetryAfter() time.Duration {
+	return e.retryAfter
+}
+
+// IsTransientError reports whether a failed download is worth retrying later.
+// Only MediaErrors, which come from the response status or the content, can be
+// permanent; local failures such as a full disk or a failing ffmpeg are not.
+func IsTransientError(err error) bool {
+	var merr *MediaError
+	if errors.As(err, &merr) {
+		return merr.IsTransient()
+	}
+	return true
 }
 
 func MediaInternalServerError(format string, a ...any) error {
@@ -33,3 +64,15 @@ func MediaBadGatewayError(format string, a ...any) error {
 func MediaNoContentError(format string, a ...any) error {
 	return &MediaError{code: http.StatusNoContent, message: fmt.Sprintf(format, a...)}
 }
+
+func MediaBadRequestError(format string, a ...any) error {
+	return &MediaError{code: http.StatusBadRequest, message: fmt.Sprintf(format, a...)}
+}
+
+func MediaStatusError(code int, format string, a ...any) error {
+	return &MediaError{code: code, message: fmt.Sprintf(format, a...)}
+}
+
+func MediaTransientError(code int, retryAfter time.Duration, format string, a ...any) error {
+	return &MediaError{code: code, message: fmt.Sprintf(format, a...), transient: true, retryAfter: retryAfter}
+}
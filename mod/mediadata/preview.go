@@ -0,0 +1,75 @@
+package mediadata
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strconv"
+)
+
+const (
+	DefaultPreviewWidth   = 320
+	DefaultPreviewSeconds = 3
+	DefaultSpriteFrames   = 10
+	DefaultSpriteWidth    = 160
+	SpriteColumns         = 5
+
+	PreviewMimeType = "image/webp"
+	SpriteMimeType  = "image/jpeg"
+
+	previewFps = 10
+)
+
+func MakePreview(videoPath string, durationMillis uint, previewWidth uint) (preview []byte, err error) {
+	if previewWidth == 0 {
+		previewWidth = DefaultPreviewWidth
+	}
+
+	start := 0.0
+	if durationMillis > DefaultPreviewSeconds*1000*2 {
+		start = float64(durationMillis) / 1000 / 10
+	}
+
+	vf := fmt.Sprintf("fps=%d,scale=%d:-2", previewFps, previewWidth)
+	cmd := exec.Command("ffmpeg", "-hide_banner", "-loglevel", "quiet",
+		"-ss", strconv.FormatFloat(start, 'f', 3, 64), "-t", strconv.Itoa(DefaultPreviewSeconds), "-i", videoPath,
+		"-an", "-vf", vf, "-loop", "0", "-c:v", "libwebp", "-quality", "60", "-f", "webp", "pipe:1")
+
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	if err = cmd.Run(); err != nil {
+		err = fmt.Errorf("failed to create preview: %s: %w", videoPath, err)
+		return
+	}
+
+	return out.Bytes(), nil
+}
+
+func MakeSprite(videoPath string, durationMillis uint, frames uint, frameWidth uint) (sprite []byte, err error) {
+	if frames == 0 {
+		frames = DefaultSpriteFrames
+	}
+	if frameWidth == 0 {
+		frameWidth = DefaultSpriteWidth
+	}
+	if durationMillis == 0 {
+		durationMillis, err = ProbeDurationMillis(videoPath)
+		if err != nil {
+			return
+		}
+	}
+
+	rows := (frames + SpriteColumns - 1) / SpriteColumns
+	vf := fmt.Sprintf("fps=%d/%.3f,scale=%d:-2,tile=%dx%d", frames, float64(durationMillis)/1000, frameWidth, SpriteColumns, rows)
+	cmd := exec.Command("ffmpeg", "-hide_banner", "-loglevel", "quiet", "-i", videoPath,
+		"-an", "-vf", vf, "-frames:v", "1", "-c:v", "mjpeg", "-f", "image2pipe", "pipe:1")
+
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	if err = cmd.Run(); err != nil {
+		err = fmt.Errorf("failed to create sprite: %s: %w", videoPath, err)
+		return
+	}
+
+	return out.Bytes(), nil
+}
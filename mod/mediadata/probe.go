@@ -0,0 +1,145 @@
+package mediadata
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+type MediaMetadata struct {
+	Width          uint
+	Height         uint
+	DurationMillis uint
+	Codec          string
+	Bitrate        uint64
+	FrameRate      float64
+}
+
+type ffprobeOutput struct {
+	Streams []struct {
+		CodecType    string `json:"codec_type"`
+		CodecName    string `json:"codec_name"`
+		Width        uint   `json:"width"`
+		Height       uint   `json:"height"`
+		BitRate      string `json:"bit_rate"`
+		AvgFrameRate string `json:"avg_frame_rate"`
+		Duration     string `json:"duration"`
+	} `json:"streams"`
+	Format struct {
+		Duration string `json:"duration"`
+		BitRate  string `json:"bit_rate"`
+	} `json:"format"`
+}
+
+func ProbeMetadata(mediaPath string, mediaType string) (metadata MediaMetadata, err error) {
+	if mediaType == "photo" {
+		return ProbeImageMetadata(mediaPath)
+	}
+	return ProbeVideoMetadata(mediaPath)
+}
+
+func ProbeImageMetadata(photoPath string) (metadata MediaMetadata, err error) {
+	data, err := os.ReadFile(photoPath)
+	if err != nil {
+		return
+	}
+
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		err = fmt.Errorf("failed to decode image header: %s: %w", photoPath, err)
+		return
+	}
+
+	metadata.Width = uint(config.Width)
+	metadata.Height = uint(config.Height)
+	metadata.Codec = format
+	if readExifOrientation(data) >= 5 {
+		metadata.Width, metadata.Height = metadata.Height, metadata.Width
+	}
+
+	return
+}
+
+func ProbeVideoMetadata(videoPath string) (metadata MediaMetadata, err error) {
+	cmd := exec.Command("ffprobe", "-v", "quiet", "-print_format", "json", "-show_format", "-show_streams", videoPath)
+
+	out, err := cmd.Output()
+	if err != nil {
+		err = fmt.Errorf("failed to probe: %s: %w", videoPath, err)
+		return
+	}
+
+	var probe ffprobeOutput
+	if err = json.Unmarshal(out, &probe); err != nil {
+		err = fmt.Errorf("failed to probe: %s: %w", videoPath, err)
+		return
+	}
+
+	duration := parseSeconds(probe.Format.Duration)
+	bitrate, _ := strconv.ParseUint(probe.Format.BitRate, 10, 64)
+
+	for _, stream := range probe.Streams {
+		if stream.CodecType != "video" {
+			continue
+		}
+		metadata.Width = stream.Width
+		metadata.Height = stream.Height
+		metadata.Codec = stream.CodecName
+		metadata.FrameRate = parseFrameRate(stream.AvgFrameRate)
+		if v, err := strconv.ParseUint(stream.BitRate, 10, 64); err == nil {
+			bitrate = v
+		}
+		if duration == 0 {
+			duration = parseSeconds(stream.Duration)
+		}
+		break
+	}
+
+	metadata.DurationMillis = uint(duration * 1000)
+	metadata.Bitrate = bitrate
+
+	return
+}
+
+func ProbeDurationMillis(videoPath string) (durationMillis uint, err error) {
+	metadata, err := ProbeVideoMetadata(videoPath)
+	if err != nil {
+		return
+	}
+	if metadata.DurationMillis == 0 {
+		err = fmt.Errorf("failed to probe duration: %s: no duration", videoPath)
+		return
+	}
+
+	return metadata.DurationMillis, nil
+}
+
+func parseSeconds(value string) float64 {
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return seconds
+}
+
+func parseFrameRate(value string) float64 {
+	num, den, found := strings.Cut(value, "/")
+	if !found {
+		return parseSeconds(value)
+	}
+
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0
+	}
+	d, err := strconv.ParseFloat(den, 64)
+	if err != nil || d == 0 {
+		return 0
+	}
+	return n / d
+}
@@ -0,0 +1,71 @@
+package mediadata
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const sniffLength = 512
+
+var mimeTypeExtensions = map[string]string{
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+	"image/gif":       ".gif",
+	"image/webp":      ".webp",
+	"image/bmp":       ".bmp",
+	"video/mp4":       ".mp4",
+	"video/quicktime": ".mov",
+	"video/webm":      ".webm",
+}
+
+func DetectMimeType(head []byte) string {
+	switch {
+	case len(head) >= 12 && bytes.Equal(head[4:8], []byte("ftyp")):
+		if bytes.Equal(head[8:12], []byte("qt  ")) {
+			return "video/quicktime"
+		}
+		return "video/mp4"
+	case len(head) >= 12 && bytes.Equal(head[0:4], []byte("RIFF")) && bytes.Equal(head[8:12], []byte("WEBP")):
+		return "image/webp"
+	case bytes.HasPrefix(head, []byte("GIF87a")) || bytes.HasPrefix(head, []byte("GIF89a")):
+		return "image/gif"
+	}
+
+	mimeType := http.DetectContentType(head)
+	if i := strings.Index(mimeType, ";"); i >= 0 {
+		mimeType = mimeType[:i]
+	}
+	return mimeType
+}
+
+func DetectMimeTypeFromFile(filePath string) (mimeType string, err error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	head := make([]byte, sniffLength)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return
+	}
+
+	return DetectMimeType(head[:n]), nil
+}
+
+func ExtensionForMimeType(mimeType string) string {
+	return mimeTypeExtensions[mimeType]
+}
+
+func canonicalFilename(filename string, mimeType string) string {
+	ext := ExtensionForMimeType(mimeType)
+	if ext == "" {
+		return filename
+	}
+	return strings.TrimSuffix(filename, filepath.Ext(filename)) + ext
+}
@@ -0,0 +1,84 @@
+package mediadata
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"os"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	DefaultThumbnailWidth = 160
+	thumbnailJpegQuality  = 85
+)
+
+func MakeThumbnailForType(mediaPath string, mediaType string, thumbnailWidth uint) (thumbnail []byte, err error) {
+	if mediaType == "photo" {
+		return MakePhotoThumbnail(mediaPath, thumbnailWidth)
+	}
+	return MakeThumbnail(mediaPath, thumbnailWidth)
+}
+
+func MakePhotoThumbnail(photoPath string, thumbnailWidth uint) (thumbnail []byte, err error) {
+	if thumbnailWidth == 0 {
+		thumbnailWidth = DefaultThumbnailWidth
+	}
+
+	data, err := os.ReadFile(photoPath)
+	if err != nil {
+		return
+	}
+
+	return makeImageThumbnail(data, photoPath, thumbnailWidth)
+}
+
+// MakeImageThumbnail makes the same thumbnail as MakePhotoThumbnail from an
+// image in memory, so its diff hash is comparable to the cached ones.
+func MakeImageThumbnail(data []byte, thumbnailWidth uint) (thumbnail []byte, err error) {
+	if thumbnailWidth == 0 {
+		thumbnailWidth = DefaultThumbnailWidth
+	}
+
+	return makeImageThumbnail(data, "uploaded image", thumbnailWidth)
+}
+
+func makeImageThumbnail(data []byte, name string, thumbnailWidth uint) (thumbnail []byte, err error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		err = fmt.Errorf("failed to decode image: %s: %w", name, err)
+		return
+	}
+
+	img = applyOrientation(img, readExifOrientation(data))
+
+	var out bytes.Buffer
+	err = jpeg.Encode(&out, scaleToWidth(img, int(thumbnailWidth)), &jpeg.Options{Quality: thumbnailJpegQuality})
+	if err != nil {
+		return
+	}
+
+	return out.Bytes(), nil
+}
+
+func scaleToWidth(img image.Image, width int) image.Image {
+	bounds := img.Bounds()
+	if bounds.Dx() == 0 || bounds.Dy() == 0 {
+		return img
+	}
+
+	height := bounds.Dy() * width / bounds.Dx()
+	if height < 1 {
+		height = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Over, nil)
+	return dst
+}
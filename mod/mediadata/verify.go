@@ -0,0 +1,80 @@
+package mediadata
+
+import (
+	"bytes"
+	"diffhash"
+	"fmt"
+	"image"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+)
+
+func (m *MediaData) SourceFilename() (filename string, err error) {
+	sourceUrl := m.SourceUrl()
+	if !m.IsVideo() {
+		sourceUrl, err = normalizeImageUrl(m.Url)
+		if err != nil {
+			return
+		}
+	}
+
+	u, err := url.Parse(sourceUrl)
+	if err != nil {
+		return
+	}
+
+	filename = path.Base(u.Path)
+	if filename == "." || filename == "/" {
+		err = fmt.Errorf("no filename in url: %s", sourceUrl)
+	}
+	return
+}
+
+func CheckMediaFile(mediaPath string, mediaType string) (err error) {
+	if mediaType != "photo" {
+		_, err = ProbeVideoMetadata(mediaPath)
+		return
+	}
+
+	data, err := os.ReadFile(mediaPath)
+	if err != nil {
+		return
+	}
+
+	_, _, err = image.Decode(bytes.NewReader(data))
+	if err != nil {
+		err = fmt.Errorf("failed to decode image: %s: %w", mediaPath, err)
+	}
+	return
+}
+
+func CacheDataFromFile(mediaPath string, mediaType string) (cacheData CacheData, err error) {
+	info, err := os.Stat(mediaPath)
+	if err != nil {
+		return
+	}
+
+	mimeType, err := DetectMimeTypeFromFile(mediaPath)
+	if err != nil {
+		return
+	}
+	if strings.HasPrefix(mimeType, "text/") {
+		err = fmt.Errorf("unexpected content: %s: %s", mediaPath, mimeType)
+		return
+	}
+
+	thumbnail, err := MakeThumbnailForType(mediaPath, mediaType, 0)
+	if err != nil {
+		return
+	}
+
+	cacheData.ContentLength = uint64(info.Size())
+	cacheData.ContentHash = diffhash.CalcDiffHashFromImage(thumbnail)
+	cacheData.CachePath = mediaPath
+	cacheData.MimeType = mimeType
+	cacheData.Thumbnail = thumbnail
+
+	return
+}
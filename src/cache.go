@@ -1,16 +1,23 @@
 package main
 
 import (
+	"blobstore"
+	"context"
 	"database/sql"
+	"datasource"
 	"diffhash"
 	"fmt"
 	"log"
 	"mediadata"
 	"os"
-	"path"
+	"os/signal"
+	"path/filepath"
+	"syscall"
 	"time"
 )
 
+const staleTempFileAge = 24 * time.Hour
+
 func cache(cacheDir string) (err error) {
 	runData, err := RunDaemon("caching.pid")
 	if err != nil {
@@ -31,47 +38,109 @@ func cache(cacheDir string) (err error) {
 
 	lines := []mediadata.MediaData{}
 	for _, media := range mediaList {
-		m := mediadata.MediaData{
-			Id:   media["mediaId"].(string),
-			Type: media["type"].(string),
-			Url:  media["url"].(string),
-		}
-		videoUrl := media["videoUrl"].(sql.NullString)
-		if videoUrl.Valid {
-			m.VideoUrl = videoUrl.String
-		}
-		lines = append(lines, m)
+		lines = append(lines, noCacheMediaToMediaData(media))
 	}
 
-	baseDir, err := makeBaseDir(cacheDir)
+	cleanupTempFiles(cacheDir)
+
+	cacheRoot, err := getCacheRoot(cacheDir)
 	if err != nil {
 		return
 	}
 
-	for _, m := range lines {
-		cacheData, err := m.DownloadMedia(baseDir)
+	layout, err := getCacheLayout(cacheLayoutTemplate)
+	if err != nil {
+		return
+	}
+
+	blobs := newBlobStore(cacheRoot)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	pool := newDownloadPool(downloadConcurrency, hostConcurrency)
+	summary := pool.run(ctx, lines, func(ctx context.Context, m mediadata.MediaData) downloadResult {
+		mediaDir, err := makeMediaDir(cacheRoot, layout, m)
 		if err != nil {
-			if merr, ok := err.(*mediadata.MediaError); ok {
-				if merr.IsNotFound() {
-					err = conn.DeleteMedia(m.Id)
-				}
-			}
 			log.Println(err)
-			continue
+			return downloadFailed
 		}
-		err = conn.SetCacheData(m.Id, cacheData.ContentLength, cacheData.ContentHash, cacheData.CachePath)
+
+		cacheData, err := m.DownloadMediaContext(ctx, mediaDir)
 		if err != nil {
 			log.Println(err)
+			if ctx.Err() != nil {
+				return downloadSkipped
+			}
+			recordDownloadFailure(conn, m.Id, err)
+			return downloadFailed
+		}
+
+		if !saveCacheData(conn, blobs, cacheRoot, m, cacheData) {
+			return downloadFailed
 		}
-		err = conn.SetThumbnail(m.Id, cacheData.Thumbnail)
+		return downloadSucceeded
+	})
+
+	log.Println("Caching finished: " + summary.String())
+	err = ctx.Err()
+
+	return
+}
+
+func noCacheMediaToMediaData(media map[string]any) mediadata.MediaData {
+	m := mediadata.MediaData{
+		Id:        media["mediaId"].(string),
+		ParentUrl: media["parentUrl"].(string),
+		Type:      media["type"].(string),
+		Url:       media["url"].(string),
+		Timestamp: media["timestamp"].(uint64),
+	}
+	videoUrl := media["videoUrl"].(sql.NullString)
+	if videoUrl.Valid {
+		m.VideoUrl = videoUrl.String
+	}
+	return m
+}
+
+func saveCacheData(conn datasource.Store, blobs *blobstore.BlobStore, cacheRoot string, m mediadata.MediaData, cacheData mediadata.CacheData) (ok bool) {
+	ok = true
+	err := conn.SetCacheData(m.Id, cacheData.ContentLength, cacheData.ContentHash, relativeCachePath(cacheRoot, cacheData.CachePath))
+	if err != nil {
+		log.Println(err)
+		ok = false
+	}
+	if cacheData.MimeType != "" {
+		err = conn.SetMimeType(m.Id, cacheData.MimeType)
 		if err != nil {
 			log.Println(err)
+			ok = false
 		}
 	}
-
+	err = storeThumbnail(conn, blobs, m.Id, cacheData.Thumbnail)
+	if err != nil {
+		log.Println(err)
+		ok = false
+	}
+	err = recordMetadata(conn, m.Id, cacheData.CachePath, m.Type)
+	if err != nil {
+		log.Println(err)
+	}
 	return
 }
 
+func recordDownloadFailure(conn datasource.Store, mediaId string, downloadErr error) {
+	var err error
+	if merr, ok := downloadErr.(*mediadata.MediaError); ok && merr.IsNotFound() {
+		err = conn.DeleteMedia(mediaId)
+	} else {
+		err = conn.RecordDownloadFailure(mediaId, downloadErr.Error(), !mediadata.IsTransientError(downloadErr))
+	}
+	if err != nil {
+		log.Println(err)
+	}
+}
+
 func cacheFromFile(cacheDir string, fromFile string) (err error) {
 	runData, err := RunDaemon("caching.pid")
 	if err != nil {
@@ -93,17 +162,77 @@ func cacheFromFile(cacheDir string, fromFile string) (err error) {
 		return
 	}
 
-	baseDir, err := makeBaseDir(cacheDir)
+	conn, err := GetConnection()
 	if err != nil {
 		return
 	}
+	defer conn.Close()
 
+	err = upsertMediaData(conn, media)
+	if err != nil {
+		return
+	}
+
+	cachedMediaList, err := conn.GetMediaWithCache()
+	if err != nil {
+		return
+	}
+
+	cached := map[string]bool{}
+	for _, mediaRecord := range cachedMediaList {
+		cached[mediaRecord.MediaId] = true
+	}
+
+	lines := []mediadata.MediaData{}
 	for _, m := range media {
-		_, err = m.DownloadMedia(baseDir)
+		if !cached[m.Id] {
+			lines = append(lines, m)
+		}
+	}
+
+	cleanupTempFiles(cacheDir)
+
+	cacheRoot, err := getCacheRoot(cacheDir)
+	if err != nil {
+		return
+	}
+
+	layout, err := getCacheLayout(cacheLayoutTemplate)
+	if err != nil {
+		return
+	}
+
+	blobs := newBlobStore(cacheRoot)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	pool := newDownloadPool(downloadConcurrency, hostConcurrency)
+	summary := pool.run(ctx, lines, func(ctx context.Context, m mediadata.MediaData) downloadResult {
+		mediaDir, err := makeMediaDir(cacheRoot, layout, m)
 		if err != nil {
 			log.Println(err)
+			return downloadFailed
 		}
-	}
+
+		cacheData, err := m.DownloadMediaContext(ctx, mediaDir)
+		if err != nil {
+			log.Println(err)
+			if ctx.Err() != nil {
+				return downloadSkipped
+			}
+			recordDownloadFailure(conn, m.Id, err)
+			return downloadFailed
+		}
+
+		if !saveCacheData(conn, blobs, cacheRoot, m, cacheData) {
+			return downloadFailed
+		}
+		return downloadSucceeded
+	})
+
+	log.Printf("Import finished: new: %d, already present: %d, failed: %d, skipped: %d\n", summary.Succeeded, len(media)-len(lines), summary.Failed, summary.Skipped)
+	err = ctx.Err()
 
 	return
 }
@@ -131,18 +260,20 @@ func createThumbnails(cacheDir string) (err error) {
 		return
 	}
 
-	_, err = makeBaseDir(cacheDir)
+	cacheRoot, err := getCacheRoot(cacheDir)
 	if err != nil {
 		return
 	}
 
+	blobs := newBlobStore(cacheRoot)
+
 	for _, cachedVideoMedia := range cachedVideoMediaList {
-		thumbnail, err := mediadata.MakeThumbnail(cachedVideoMedia.Path, 0)
+		thumbnail, err := mediadata.MakeThumbnail(cacheFilePath(cacheRoot, cachedVideoMedia.Path), 0)
 		if err != nil {
 			log.Println(err)
 			continue
 		}
-		if err = conn.SetThumbnail(cachedVideoMedia.Id, thumbnail); err != nil {
+		if err = storeThumbnail(conn, blobs, cachedVideoMedia.Id, thumbnail); err != nil {
 			log.Println(err)
 			continue
 		}
@@ -152,7 +283,7 @@ func createThumbnails(cacheDir string) (err error) {
 	return
 }
 
-func calculateDiffHashs() (err error) {
+func calculateDiffHashs(cacheDir string) (err error) {
 	runData, err := RunDaemon("caching.pid")
 	if err != nil {
 		return
@@ -165,44 +296,133 @@ func calculateDiffHashs() (err error) {
 	}
 	defer conn.Close()
 
+	hashers, err := getHashers(hashAlgorithms)
+	if err != nil {
+		return
+	}
+
 	unhashedMediaList, err := conn.GetUnhashedMedia()
 	if err != nil {
 		return
 	}
 
-	if len(unhashedMediaList) == 0 {
+	transforms := append([]string{diffhash.TransformNone}, diffhash.Transforms()...)
+	pending := map[string]datasource.UnhashedMedia{}
+	for _, hasher := range hashers {
+		for _, transform := range transforms {
+			if isContentHash(hasher.Algorithm(), transform) {
+				continue
+			}
+			var mediaList []datasource.UnhashedMedia
+			mediaList, err = conn.GetMediaWithoutHash(hasher.Algorithm(), transform)
+			if err != nil {
+				return
+			}
+			for _, media := range mediaList {
+				pending[media.MediaId] = media
+			}
+		}
+	}
+
+	if len(unhashedMediaList) == 0 && len(pending) == 0 {
 		err = fmt.Errorf("no cached media")
 		return
 	}
 
+	blobs, err := getBlobStore(cacheDir)
+	if err != nil {
+		return
+	}
+
 	for _, unhashedMedia := range unhashedMediaList {
-		contentHash := diffhash.CalcDiffHashFromImage(unhashedMedia.Thumbnail)
+		thumbnail, err := loadUnhashedThumbnail(blobs, unhashedMedia)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		contentHash := diffhash.CalcDiffHashFromImage(thumbnail)
 		log.Printf("Diff-hashed: %s %016x\n", unhashedMedia.MediaId, contentHash)
 		conn.SetContentHashData(unhashedMedia.MediaId, contentHash)
 	}
 
+	for _, unhashedMedia := range pending {
+		thumbnail, err := loadUnhashedThumbnail(blobs, unhashedMedia)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		hashes, err := diffhash.CalcHashesFromImage(hashers, transforms, thumbnail)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		for _, hash := range hashes {
+			if isContentHash(hash.Algorithm, hash.Transform) {
+				continue
+			}
+			if err = conn.SetMediaHash(unhashedMedia.MediaId, hash.Algorithm, hash.Transform, hash.String()); err != nil {
+				log.Println(err)
+			}
+		}
+		log.Printf("Hashed: %s (%d hashes)\n", unhashedMedia.MediaId, len(hashes))
+	}
+
 	return
 }
 
-func makeBaseDir(cacheDir string) (baseDir string, err error) {
-	if cacheDir == "" {
-		cacheDir, err = ExecPath(".cache")
-		if err != nil {
-			return
-		}
+// The untransformed dhash lives in media.content_hash.
+func isContentHash(algorithm string, transform string) bool {
+	return algorithm == diffhash.AlgorithmDiffHash && transform == diffhash.TransformNone
+}
+
+func loadUnhashedThumbnail(blobs *blobstore.BlobStore, unhashedMedia datasource.UnhashedMedia) ([]byte, error) {
+	if unhashedMedia.ThumbnailKey != "" {
+		return blobs.Get(unhashedMedia.ThumbnailKey)
+	}
+	return unhashedMedia.Thumbnail, nil
+}
+
+func getCacheRoot(cacheDir string) (string, error) {
+	if cacheDir != "" {
+		return filepath.Abs(cacheDir)
 	}
 
-	year, month, day := time.Now().Date()
-	baseDir = path.Join(cacheDir, fmt.Sprintf("%04d%02d%02d", year, int(month), day))
-	err = os.MkdirAll(baseDir, os.ModePerm)
+	appConfig, err := GetAppConfig()
 	if err != nil {
-		if !os.IsExist(err) {
-			return
-		}
-		err = nil
+		return "", err
+	}
+	if appConfig.CacheDir != "" {
+		return appConfig.CacheDir, nil
+	}
+
+	return ExecPath(".cache")
+}
+
+func getBlobStore(cacheDir string) (blobs *blobstore.BlobStore, err error) {
+	cacheRoot, err := getCacheRoot(cacheDir)
+	if err != nil {
+		return
 	}
 
-	os.Chmod(baseDir, 0777)
+	return newBlobStore(cacheRoot), nil
+}
 
-	return
+func newBlobStore(cacheRoot string) *blobstore.BlobStore {
+	return blobstore.New(filepath.Join(cacheRoot, "blobs"))
+}
+
+func cleanupTempFiles(cacheDir string) {
+	cacheRoot, err := getCacheRoot(cacheDir)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	removed, err := mediadata.CleanupTempFiles(cacheRoot, staleTempFileAge)
+	if err != nil {
+		log.Println(err)
+	}
+	if removed > 0 {
+		log.Printf("Removed %d stale temp files\n", removed)
+	}
 }
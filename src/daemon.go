@@ -2,38 +2,80 @@ package main
 
 import (
 	"datasource"
+	"diffhash"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"mediadata"
+	"mime"
 	"net/http"
 	"net/http/cgi"
 	"reflect"
 	"router"
 	"strconv"
-	"strings"
 
 	"github.com/emurenMRz/twxfilter_backend/internal/mapper"
 )
 
-func daemon() (err error) {
+func daemon(listenAddr string) (err error) {
 	conn, err := GetConnection()
 	if err != nil {
 		return
 	}
 	defer conn.Close()
 
+	cacheRoot, err := getCacheRoot(cacheDir)
+	if err != nil {
+		return
+	}
+
+	registerEndpoints(conn, cacheRoot)
+
+	if listenAddr != "" {
+		return serve(listenAddr, router.CorsRouter)
+	}
+
+	return cgi.Serve(router.CorsRouter)
+}
+
+func registerEndpoints(conn datasource.Store, cacheRoot string) {
 	selfName := datasource.GetSelfName()
+	blobs := newBlobStore(cacheRoot)
 
 	router.RegistorEndpoint("GET /"+selfName+"/media/duplicated", func(w http.ResponseWriter, r *http.Request, values router.PathValues) {
-		duplicatedMediaList, err := conn.GetHashCluster()
+		options := datasource.NewHashClusterOptions()
+		if v := getUint64FromQuery(r, "max-distance"); len(v) > 0 {
+			options.MaxDistance = int(min(v[0], datasource.MaxDuplicateDistance))
+		}
+		if v := getUint64FromQuery(r, "min-size"); len(v) > 0 {
+			options.MinSize = int(min(v[0], math.MaxInt32))
+		}
+		options.Type = r.URL.Query().Get("type")
+		if v := r.URL.Query().Get("transforms"); v != "" {
+			matchTransforms, err := strconv.ParseBool(v)
+			if err != nil {
+				handleError(w, NewDaemonError(err, http.StatusBadRequest, "invalid transforms"))
+				return
+			}
+			options.MatchTransforms = matchTransforms
+		}
+		options.Algorithms = splitAlgorithms(r.URL.Query()["algorithm"]...)
+		for _, algorithm := range options.Algorithms {
+			if _, err := diffhash.NewHasher(algorithm); err != nil {
+				handleError(w, NewDaemonError(err, http.StatusBadRequest, "unknown hash algorithm"))
+				return
+			}
+		}
+
+		duplicatedMediaList, err := conn.GetHashCluster(options)
 		if err != nil {
 			handleError(w, err)
 			return
 		}
 
-		o, err := json.Marshal(mapper.MediaRecordSetListToMediaDataSetList(duplicatedMediaList))
+		o, err := json.Marshal(mapper.HashClusterListToDuplicatedMediaSetList(duplicatedMediaList))
 		if err != nil {
 			handleError(w, err)
 			return
@@ -59,19 +101,17 @@ func daemon() (err error) {
 
 		mediaSetList := [][]datasource.MediaRecord{}
 		for _, setList := range mediaObjectSetList {
-			condition := []string{}
-			args := []any{}
-			for i, set := range setList {
+			patterns := []string{}
+			for _, set := range setList {
 				if v, ok := set.(string); ok {
-					condition = append(condition, fmt.Sprintf(`cache_path LIKE $%d`, i+1))
-					args = append(args, fmt.Sprintf(`%%%s%%`, v))
+					patterns = append(patterns, v)
 				} else if _, ok := set.(map[string]string); ok {
 					log.Printf("no implement: map[string]string\n")
 				} else {
 					log.Printf("unkown type: %s\n", reflect.TypeOf(set))
 				}
 			}
-			mediaSet, err := conn.GetMediaDataSet(strings.Join(condition, " OR "), args...)
+			mediaSet, err := conn.GetMediaByCachePath(patterns)
 			if err != nil {
 				handleError(w, err)
 				return
@@ -91,6 +131,82 @@ func daemon() (err error) {
 		fmt.Fprint(w, string(o))
 	})
 
+	router.RegistorEndpoint("GET /"+selfName+"/media/:id/similar", func(w http.ResponseWriter, r *http.Request, values router.PathValues) {
+		id := values["id"]
+
+		mediaRecord, err := conn.GetMediaByID(id)
+		if err != nil {
+			handleError(w, NewDaemonError(err, http.StatusNotFound, ""))
+			return
+		}
+
+		if !mediaRecord.ContentHash.Valid || mediaRecord.ContentHash.Int64 == 0 {
+			handleError(w, NewDaemonError(nil, http.StatusNotFound, "no content hash"))
+			return
+		}
+
+		maxDistance, limit := getSimilarMediaQuery(r)
+		similarMediaList, err := conn.GetSimilarMedia(uint64(mediaRecord.ContentHash.Int64), maxDistance, limit+1)
+		if err != nil {
+			handleError(w, err)
+			return
+		}
+
+		neighbors := []datasource.SimilarMedia{}
+		for _, m := range similarMediaList {
+			if m.MediaId != id && len(neighbors) < limit {
+				neighbors = append(neighbors, m)
+			}
+		}
+
+		o, err := json.Marshal(mapper.SimilarMediaListToSimilarMediaList(neighbors))
+		if err != nil {
+			handleError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		fmt.Fprint(w, string(o))
+	})
+
+	router.RegistorEndpoint("POST /"+selfName+"/media/similar", func(w http.ResponseWriter, r *http.Request, values router.PathValues) {
+		r.Body = http.MaxBytesReader(w, r.Body, maxSimilarImageSize)
+
+		image, err := readUploadedImage(r)
+		if err != nil {
+			handleError(w, NewDaemonError(err, http.StatusBadRequest, ""))
+			return
+		}
+
+		thumbnail, err := mediadata.MakeImageThumbnail(image, 0)
+		if err != nil {
+			handleError(w, NewDaemonError(err, http.StatusBadRequest, ""))
+			return
+		}
+
+		contentHash := diffhash.CalcDiffHashFromImage(thumbnail)
+		if contentHash == 0 {
+			handleError(w, NewDaemonError(nil, http.StatusBadRequest, "cannot hash image"))
+			return
+		}
+
+		maxDistance, limit := getSimilarMediaQuery(r)
+		similarMediaList, err := conn.GetSimilarMedia(contentHash, maxDistance, limit)
+		if err != nil {
+			handleError(w, err)
+			return
+		}
+
+		o, err := json.Marshal(mapper.SimilarMediaListToSimilarMediaList(similarMediaList))
+		if err != nil {
+			handleError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		fmt.Fprint(w, string(o))
+	})
+
 	router.RegistorEndpoint("GET /"+selfName+"/media/:id", func(w http.ResponseWriter, r *http.Request, values router.PathValues) {
 		id := values["id"]
 
@@ -106,16 +222,22 @@ func daemon() (err error) {
 		}
 
 		type MediaObject struct {
-			MediaId        string `json:"id"`
-			ParentUrl      string `json:"parentUrl"`
-			Type           string `json:"type"`
-			Url            string `json:"url"`
-			Timestamp      uint64 `json:"timestamp"`
-			DurationMillis int32  `json:"durationMillis,omitempty"`
-			VideoUrl       string `json:"videoUrl,omitempty"`
-			ContentLength  int64  `json:"contentLength,omitempty"`
-			CachePath      string `json:"cachePath,omitempty"`
-			Removed        bool   `json:"removed"`
+			MediaId        string  `json:"id"`
+			ParentUrl      string  `json:"parentUrl"`
+			Type           string  `json:"type"`
+			Url            string  `json:"url"`
+			Timestamp      uint64  `json:"timestamp"`
+			DurationMillis int32   `json:"durationMillis,omitempty"`
+			VideoUrl       string  `json:"videoUrl,omitempty"`
+			ContentLength  int64   `json:"contentLength,omitempty"`
+			CachePath      string  `json:"cachePath,omitempty"`
+			MimeType       string  `json:"mimeType,omitempty"`
+			Width          uint    `json:"width,omitempty"`
+			Height         uint    `json:"height,omitempty"`
+			Codec          string  `json:"codec,omitempty"`
+			Bitrate        uint64  `json:"bitrate,omitempty"`
+			FrameRate      float64 `json:"frameRate,omitempty"`
+			Removed        bool    `json:"removed"`
 		}
 
 		m := MediaObject{
@@ -136,6 +258,24 @@ func daemon() (err error) {
 		if mediaRecord.ContentLength.Valid {
 			m.ContentLength = mediaRecord.ContentLength.Int64
 		}
+		if mediaRecord.MimeType.Valid {
+			m.MimeType = mediaRecord.MimeType.String
+		}
+		if mediaRecord.Width.Valid {
+			m.Width = uint(mediaRecord.Width.Int32)
+		}
+		if mediaRecord.Height.Valid {
+			m.Height = uint(mediaRecord.Height.Int32)
+		}
+		if mediaRecord.Codec.Valid {
+			m.Codec = mediaRecord.Codec.String
+		}
+		if mediaRecord.Bitrate.Valid {
+			m.Bitrate = uint64(mediaRecord.Bitrate.Int64)
+		}
+		if mediaRecord.FrameRate.Valid {
+			m.FrameRate = mediaRecord.FrameRate.Float64
+		}
 
 		o, err := json.Marshal(m)
 		if err != nil {
@@ -147,6 +287,22 @@ func daemon() (err error) {
 		fmt.Fprint(w, string(o))
 	})
 
+	router.RegistorEndpoint("GET /"+selfName+"/file/:id", func(w http.ResponseWriter, r *http.Request, values router.PathValues) {
+		id := values["id"]
+
+		mediaRecord, err := conn.GetMediaByID(id)
+		if err != nil {
+			handleError(w, NewDaemonError(err, http.StatusNotFound, ""))
+			return
+		}
+
+		err = serveCachedFile(w, r, cacheRoot, mediaRecord)
+		if err != nil {
+			handleError(w, err)
+			return
+		}
+	})
+
 	router.RegistorEndpoint("GET /"+selfName+"/catalog/index", func(w http.ResponseWriter, r *http.Request, values router.PathValues) {
 		minSizes := getUint64FromQuery(r, "min-size")
 		minSize := uint64(0)
@@ -191,19 +347,62 @@ func daemon() (err error) {
 	router.RegistorEndpoint("GET /"+selfName+"/thumbnail/:id", func(w http.ResponseWriter, r *http.Request, values router.PathValues) {
 		id := values["id"]
 
-		thumbnail, err := conn.GetThumbnailByID(id)
+		width := uint(mediadata.DefaultThumbnailWidth)
+		if widths := getUint64FromQuery(r, "w"); len(widths) > 0 {
+			width = uint(widths[0])
+		}
+
+		thumbnail, err := getThumbnail(conn, blobs, cacheRoot, id, width)
 		if err != nil {
 			handleError(w, err)
 			return
 		}
 
-		if len(thumbnail) == 0 {
-			handleError(w, fmt.Errorf("no thumbnail"))
+		writeCacheableContent(w, r, "image/jpeg", thumbnail)
+	})
+
+	router.RegistorEndpoint("GET /"+selfName+"/preview/:id", func(w http.ResponseWriter, r *http.Request, values router.PathValues) {
+		id := values["id"]
+
+		key, err := conn.GetPreviewKeyByID(id)
+		if err != nil {
+			handleError(w, NewDaemonError(err, http.StatusNotFound, ""))
+			return
+		}
+		if key == "" {
+			handleError(w, NewDaemonError(nil, http.StatusNotFound, "no preview"))
+			return
+		}
+
+		preview, err := readBlob(blobs, key)
+		if err != nil {
+			handleError(w, err)
 			return
 		}
 
-		w.Header().Set("Content-Type", "image/jpeg")
-		w.Write(thumbnail)
+		writeCacheableContent(w, r, mediadata.PreviewMimeType, preview)
+	})
+
+	router.RegistorEndpoint("GET /"+selfName+"/sprite/:id", func(w http.ResponseWriter, r *http.Request, values router.PathValues) {
+		id := values["id"]
+
+		key, err := conn.GetSpriteKeyByID(id)
+		if err != nil {
+			handleError(w, NewDaemonError(err, http.StatusNotFound, ""))
+			return
+		}
+		if key == "" {
+			handleError(w, NewDaemonError(nil, http.StatusNotFound, "no sprite"))
+			return
+		}
+
+		sprite, err := readBlob(blobs, key)
+		if err != nil {
+			handleError(w, err)
+			return
+		}
+
+		writeCacheableContent(w, r, mediadata.SpriteMimeType, sprite)
 	})
 
 	router.RegistorEndpoint("POST /"+selfName+"/media", func(w http.ResponseWriter, r *http.Request, values router.PathValues) {
@@ -219,34 +418,10 @@ func daemon() (err error) {
 			return
 		}
 
-		columns := []string{"media_id", "parent_url", "type", "url", "timestamp", "duration_millis", "video_url"}
-		var valueTable [][]any
-		for _, m := range media {
-			row := []any{
-				m.Id,
-				m.ParentUrl,
-				m.Type,
-				m.Url,
-				m.Timestamp,
-				m.DurationMillis,
-				m.VideoUrl,
-			}
-			if row[5] == 0 {
-				row[5] = nil
-			}
-			if row[6] == "" {
-				row[6] = nil
-			}
-
-			valueTable = append(valueTable, row)
-		}
-
-		if len(valueTable) > 0 {
-			err = conn.UpsertMedia(columns, valueTable)
-			if err != nil {
-				handleError(w, err)
-				return
-			}
+		err = upsertMediaData(conn, media)
+		if err != nil {
+			handleError(w, err)
+			return
 		}
 
 		mediaList, err := conn.GetMedia()
@@ -303,7 +478,7 @@ func daemon() (err error) {
 	router.RegistorEndpoint("DELETE /"+selfName+"/cache-file/:id", func(w http.ResponseWriter, r *http.Request, values router.PathValues) {
 		id := values["id"]
 
-		err := deleteCacheFileCore(conn, id)
+		err := deleteCacheFileCore(conn, cacheRoot, id)
 		if err != nil {
 			handleError(w, err)
 			return
@@ -312,9 +487,6 @@ func daemon() (err error) {
 		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 		fmt.Fprint(w, "Succeed")
 	})
-
-	cgi.Serve(router.CorsRouter)
-	return
 }
 
 func handleError(w http.ResponseWriter, err error) {
@@ -325,7 +497,9 @@ func handleError(w http.ResponseWriter, err error) {
 
 	if cerr, ok := err.(*DaemonError); ok {
 		msg = cerr.Text()
-		code = cerr.Code()
+		if cerr.Code() != 0 {
+			code = cerr.Code()
+		}
 	}
 
 	http.Error(w, msg, code)
@@ -344,7 +518,80 @@ func getUint64FromQuery(r *http.Request, key string) []uint64 {
 	return ret
 }
 
-func deleteCacheFileCore(conn *datasource.Database, id string) error {
+const (
+	defaultSimilarLimit = 20
+	maxSimilarLimit     = 1000
+	maxSimilarImageSize = 32 << 20
+)
+
+func getSimilarMediaQuery(r *http.Request) (maxDistance int, limit int) {
+	maxDistance = datasource.DefaultDuplicateDistance
+	if v := getUint64FromQuery(r, "max-distance"); len(v) > 0 {
+		maxDistance = int(min(v[0], datasource.MaxDuplicateDistance))
+	}
+
+	limit = defaultSimilarLimit
+	if v := getUint64FromQuery(r, "limit"); len(v) > 0 && v[0] > 0 {
+		limit = int(min(v[0], maxSimilarLimit))
+	}
+	return
+}
+
+func readUploadedImage(r *http.Request) (image []byte, err error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		return io.ReadAll(r.Body)
+	}
+
+	file, _, err := r.FormFile("image")
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	return io.ReadAll(file)
+}
+
+const upsertBatchSize = 1000
+
+func upsertMediaData(conn datasource.Store, media []mediadata.MediaData) error {
+	for len(media) > upsertBatchSize {
+		if err := upsertMediaData(conn, media[:upsertBatchSize]); err != nil {
+			return err
+		}
+		media = media[upsertBatchSize:]
+	}
+
+	columns := []string{"media_id", "parent_url", "type", "url", "timestamp", "duration_millis", "video_url"}
+	var valueTable [][]any
+	for _, m := range media {
+		row := []any{
+			m.Id,
+			m.ParentUrl,
+			m.Type,
+			m.Url,
+			m.Timestamp,
+			m.DurationMillis,
+			m.VideoUrl,
+		}
+		if m.DurationMillis == 0 {
+			row[5] = nil
+		}
+		if m.VideoUrl == "" {
+			row[6] = nil
+		}
+
+		valueTable = append(valueTable, row)
+	}
+
+	if len(valueTable) == 0 {
+		return nil
+	}
+
+	return conn.UpsertMedia(columns, valueTable)
+}
+
+func deleteCacheFileCore(conn datasource.Store, cacheRoot string, id string) error {
 	mediaRecord, err := conn.GetMediaByID(id)
 	if err != nil {
 		return NewDaemonError(err, http.StatusNotFound, "")
@@ -354,7 +601,7 @@ func deleteCacheFileCore(conn *datasource.Database, id string) error {
 		return NewDaemonError(nil, http.StatusNoContent, "No content")
 	}
 
-	cachePath := mediaRecord.CachePath.String
+	cachePath := cacheFilePath(cacheRoot, mediaRecord.CachePath.String)
 	mediaType := mediaRecord.Type
 	err = mediadata.DeleteCacheFile(cachePath, mediaType)
 	if err != nil {
@@ -369,12 +616,17 @@ func deleteCacheFileCore(conn *datasource.Database, id string) error {
 	return nil
 }
 
-func DeleteCacheFile(id string) error {
+func DeleteCacheFile(cacheDir string, id string) error {
 	conn, err := GetConnection()
 	if err != nil {
 		return err
 	}
 	defer conn.Close()
 
-	return deleteCacheFileCore(conn, id)
+	cacheRoot, err := getCacheRoot(cacheDir)
+	if err != nil {
+		return err
+	}
+
+	return deleteCacheFileCore(conn, cacheRoot, id)
 }
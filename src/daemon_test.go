@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"datasource"
+	"diffhash"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math/rand"
+	"mediadata"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"router"
+	"testing"
+
+	"github.com/emurenMRz/twxfilter_backend/internal/models"
+)
+
+// testPhoto is noisy with barely brighter cells on the diff hash grid, so
+// hashing it at full size gives a different diff hash than hashing its
+// thumbnail.
+func testPhoto(t *testing.T) []byte {
+	rnd := rand.New(rand.NewSource(5))
+	cells := make([]int, 9*8)
+	for i := range cells {
+		cells[i] = rnd.Intn(2)
+	}
+
+	img := image.NewGray(image.Rect(0, 0, 1200, 900))
+	for y := 0; y < 900; y++ {
+		for x := 0; x < 1200; x++ {
+			v := 110 + cells[y*8/900*9+x*9/1200] + rnd.Intn(80) - 40
+			img.SetGray(x, y, color.Gray{Y: uint8(v)})
+		}
+	}
+
+	var out bytes.Buffer
+	if err := jpeg.Encode(&out, img, &jpeg.Options{Quality: 90}); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+func TestPostSimilarMediaFindsCachedPhoto(t *testing.T) {
+	cacheRoot := t.TempDir()
+	photo := testPhoto(t)
+	if err := os.MkdirAll(filepath.Join(cacheRoot, "1"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	photoPath := filepath.Join(cacheRoot, "1", "a.jpg")
+	if err := os.WriteFile(photoPath, photo, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	// Hash the photo the way caching does.
+	thumbnail, err := mediadata.MakeThumbnailForType(photoPath, "photo", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := datasource.NewMemoryStore()
+	err = conn.UpsertMedia([]string{"media_id", "parent_url", "type", "url", "timestamp"}, [][]any{{"a", "https://x.com/p/a", "photo", "https://pbs.twimg.com/a.jpg", uint64(1700000000000)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = conn.SetCacheData("a", uint64(len(photo)), diffhash.CalcDiffHashFromImage(thumbnail), "1/a.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	registerEndpoints(conn, cacheRoot)
+
+	req := httptest.NewRequest(http.MethodPost, "/"+datasource.GetSelfName()+"/media/similar?max-distance=0", bytes.NewReader(photo))
+	rec := httptest.NewRecorder()
+	router.CorsRouter.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var similarMediaList []models.SimilarMedia
+	if err = json.Unmarshal(rec.Body.Bytes(), &similarMediaList); err != nil {
+		t.Fatal(err)
+	}
+	if len(similarMediaList) != 1 || similarMediaList[0].Id != "a" || similarMediaList[0].Distance != 0 {
+		t.Errorf("got %+v", similarMediaList)
+	}
+}
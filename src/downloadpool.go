@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"mediadata"
+	"net/url"
+	"sync"
+)
+
+type downloadResult int
+
+const (
+	downloadSucceeded downloadResult = iota
+	downloadFailed
+	downloadSkipped
+)
+
+type downloadSummary struct {
+	Succeeded int
+	Failed    int
+	Skipped   int
+}
+
+func (s *downloadSummary) add(result downloadResult) {
+	switch result {
+	case downloadSucceeded:
+		s.Succeeded++
+	case downloadFailed:
+		s.Failed++
+	default:
+		s.Skipped++
+	}
+}
+
+func (s downloadSummary) String() string {
+	return fmt.Sprintf("succeeded: %d, failed: %d, skipped: %d", s.Succeeded, s.Failed, s.Skipped)
+}
+
+type downloadTask = func(ctx context.Context, m mediadata.MediaData) downloadResult
+
+type downloadPool struct {
+	concurrency     int
+	hostConcurrency int
+
+	mu    sync.Mutex
+	hosts map[string]chan struct{}
+}
+
+func newDownloadPool(concurrency int, hostConcurrency int) *downloadPool {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	if hostConcurrency < 1 || hostConcurrency > concurrency {
+		hostConcurrency = concurrency
+	}
+
+	return &downloadPool{
+		concurrency:     concurrency,
+		hostConcurrency: hostConcurrency,
+		hosts:           make(map[string]chan struct{}),
+	}
+}
+
+func (p *downloadPool) hostSlots(host string) chan struct{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	slots, ok := p.hosts[host]
+	if !ok {
+		slots = make(chan struct{}, p.hostConcurrency)
+		p.hosts[host] = slots
+	}
+	return slots
+}
+
+func (p *downloadPool) run(ctx context.Context, mediaList []mediadata.MediaData, task downloadTask) (summary downloadSummary) {
+	jobs := make(chan mediadata.MediaData)
+	results := make(chan downloadResult)
+
+	var wg sync.WaitGroup
+	for i := 0; i < p.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for m := range jobs {
+				results <- p.process(ctx, m, task)
+			}
+		}()
+	}
+
+	go func() {
+		defer close(jobs)
+		for _, m := range mediaList {
+			jobs <- m
+		}
+	}()
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	for result := range results {
+		summary.add(result)
+	}
+
+	return
+}
+
+func (p *downloadPool) process(ctx context.Context, m mediadata.MediaData, task downloadTask) downloadResult {
+	if ctx.Err() != nil {
+		return downloadSkipped
+	}
+
+	u, err := url.Parse(m.SourceUrl())
+	if err != nil || u.Host == "" {
+		return downloadSkipped
+	}
+
+	slots := p.hostSlots(u.Host)
+	select {
+	case slots <- struct{}{}:
+	case <-ctx.Done():
+		return downloadSkipped
+	}
+	defer func() { <-slots }()
+
+	result := task(ctx, m)
+	if result == downloadFailed && ctx.Err() != nil {
+		return downloadSkipped
+	}
+	return result
+}
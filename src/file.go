@@ -0,0 +1,100 @@
+package main
+
+import (
+	"datasource"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func resolveCachedPath(cacheRoot string, cachePath string) (resolvedPath string, err error) {
+	root, err := filepath.Abs(cacheRoot)
+	if err != nil {
+		return
+	}
+	root, err = filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", NewDaemonError(err, http.StatusNotFound, "no cache")
+	}
+
+	resolvedPath, err = filepath.EvalSymlinks(cachePath)
+	if err != nil {
+		return "", NewDaemonError(err, http.StatusNotFound, "no cache")
+	}
+	resolvedPath, err = filepath.Abs(resolvedPath)
+	if err != nil {
+		return
+	}
+
+	rel, err := filepath.Rel(root, resolvedPath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", NewDaemonError(nil, http.StatusForbidden, "file is outside the cache root")
+	}
+
+	return
+}
+
+// fileETag is weak when it is built from the diff hash, which identifies the
+// picture rather than the bytes of the file.
+func fileETag(mediaRecord datasource.MediaRecord, info os.FileInfo) string {
+	if mediaRecord.ContentHash.Valid {
+		return fmt.Sprintf(`W/"%016x-%x"`, uint64(mediaRecord.ContentHash.Int64), info.Size())
+	}
+	return fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size())
+}
+
+func wantsDownload(r *http.Request) bool {
+	q := r.URL.Query()
+	if !q.Has("download") {
+		return false
+	}
+	v := q.Get("download")
+	return v != "0" && v != "false"
+}
+
+func serveCachedFile(w http.ResponseWriter, r *http.Request, cacheRoot string, mediaRecord datasource.MediaRecord) (err error) {
+	if !mediaRecord.CachePath.Valid {
+		return NewDaemonError(nil, http.StatusNotFound, "no cache")
+	}
+
+	filePath, err := resolveCachedPath(cacheRoot, cacheFilePath(cacheRoot, mediaRecord.CachePath.String))
+	if err != nil {
+		return
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return NewDaemonError(err, http.StatusNotFound, "no cache")
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return
+	}
+	if info.IsDir() {
+		return NewDaemonError(nil, http.StatusNotFound, "no cache")
+	}
+
+	contentType := mime.TypeByExtension(filepath.Ext(filePath))
+	if mediaRecord.MimeType.Valid {
+		contentType = mediaRecord.MimeType.String
+	}
+	if contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+
+	disposition := "inline"
+	if wantsDownload(r) {
+		disposition = "attachment"
+	}
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": filepath.Base(filePath)}))
+	w.Header().Set("ETag", fileETag(mediaRecord, info))
+	w.Header().Set("Cache-Control", thumbnailCacheControl)
+
+	http.ServeContent(w, r, filepath.Base(filePath), info.ModTime(), file)
+	return
+}
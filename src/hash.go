@@ -0,0 +1,43 @@
+package main
+
+import (
+	"diffhash"
+	"strings"
+)
+
+func splitAlgorithms(values ...string) (algorithms []string) {
+	for _, value := range values {
+		for _, algorithm := range strings.Split(value, ",") {
+			if algorithm = strings.TrimSpace(algorithm); algorithm != "" {
+				algorithms = append(algorithms, algorithm)
+			}
+		}
+	}
+	return
+}
+
+func getHashers(algorithms string) (hashers []diffhash.Hasher, err error) {
+	names := splitAlgorithms(algorithms)
+	if len(names) == 0 {
+		appConfig, err := GetAppConfig()
+		if err != nil {
+			return nil, err
+		}
+		names = splitAlgorithms(appConfig.HashAlgorithms...)
+	}
+
+	seen := map[string]bool{}
+	for _, name := range append([]string{diffhash.AlgorithmDiffHash}, names...) {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		hasher, err := diffhash.NewHasher(name)
+		if err != nil {
+			return nil, err
+		}
+		hashers = append(hashers, hasher)
+	}
+	return
+}
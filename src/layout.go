@@ -0,0 +1,118 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"mediadata"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const defaultCacheLayout = "{download_date}"
+
+var layoutPlaceholder = regexp.MustCompile(`\{[^{}]*\}`)
+var unsafePathChars = regexp.MustCompile(`[^0-9A-Za-z_.-]`)
+
+type cacheLayout string
+
+func getCacheLayout(layout string) (cacheLayout, error) {
+	if layout == "" {
+		appConfig, err := GetAppConfig()
+		if err != nil {
+			return "", err
+		}
+		layout = appConfig.CacheLayout
+	}
+	if layout == "" {
+		layout = defaultCacheLayout
+	}
+
+	l := cacheLayout(layout)
+	return l, l.Validate()
+}
+
+func (l cacheLayout) Validate() error {
+	if filepath.IsAbs(string(l)) {
+		return fmt.Errorf("cache layout must be relative: %s", l)
+	}
+	for _, placeholder := range layoutPlaceholder.FindAllString(string(l), -1) {
+		switch placeholder {
+		case "{download_date}", "{tweet_date}", "{author}", "{shard}":
+		default:
+			return fmt.Errorf("unknown cache layout placeholder: %s", placeholder)
+		}
+	}
+	for _, segment := range strings.Split(string(l), "/") {
+		if segment == ".." {
+			return fmt.Errorf("cache layout must stay inside the cache root: %s", l)
+		}
+	}
+	return nil
+}
+
+func (l cacheLayout) dir(m mediadata.MediaData, downloadedAt time.Time) string {
+	dir := layoutPlaceholder.ReplaceAllStringFunc(string(l), func(placeholder string) string {
+		switch placeholder {
+		case "{download_date}":
+			return downloadedAt.Format("20060102")
+		case "{tweet_date}":
+			if m.Timestamp == 0 {
+				return "unknown"
+			}
+			return time.UnixMilli(int64(m.Timestamp)).Format("20060102")
+		case "{author}":
+			return mediaAuthor(m.ParentUrl)
+		case "{shard}":
+			sum := sha1.Sum([]byte(m.Id))
+			return hex.EncodeToString(sum[:1])
+		}
+		return placeholder
+	})
+	return filepath.Clean(filepath.FromSlash(dir))
+}
+
+func mediaAuthor(parentUrl string) string {
+	u, err := url.Parse(parentUrl)
+	if err != nil {
+		return "unknown"
+	}
+
+	author, _, _ := strings.Cut(strings.TrimPrefix(u.Path, "/"), "/")
+	author = unsafePathChars.ReplaceAllString(author, "_")
+	if author == "" || strings.Trim(author, ".") == "" {
+		return "unknown"
+	}
+	return author
+}
+
+func makeMediaDir(cacheRoot string, layout cacheLayout, m mediadata.MediaData) (mediaDir string, err error) {
+	mediaDir = filepath.Join(cacheRoot, layout.dir(m, time.Now()))
+	err = os.MkdirAll(mediaDir, os.ModePerm)
+	if err != nil {
+		return
+	}
+
+	os.Chmod(mediaDir, 0777)
+
+	return
+}
+
+func cacheFilePath(cacheRoot string, cachePath string) string {
+	if filepath.IsAbs(cachePath) {
+		return cachePath
+	}
+	return filepath.Join(cacheRoot, filepath.FromSlash(cachePath))
+}
+
+func relativeCachePath(cacheRoot string, filePath string) string {
+	rel, err := filepath.Rel(cacheRoot, filePath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return filePath
+	}
+	return filepath.ToSlash(rel)
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"flag"
 	"log"
+	"mediadata"
 )
 
 var fromFile string
@@ -11,20 +12,97 @@ var deleteCacheFile string
 var cachingMode bool
 var makeThumbnailMode bool
 var calcDiffHashMode bool
+var makePreviewMode bool
+var probeMetadataMode bool
+var listenAddr string
+var cacheLayoutTemplate string
+var hashAlgorithms string
+var relocateCacheMode bool
+var relocateTo string
+var verifyCacheMode bool
+var rebuildFromCacheMode bool
+var fixMode bool
+var migrateMode bool
+var migrateThumbnailsMode bool
+var downloadConcurrency int
+var hostConcurrency int
+var maxDownloadSize int64
 
 func init() {
 	flag.StringVar(&fromFile, "f", "", "Start caching media from an export file")
 	flag.StringVar(&cacheDir, "c", "", "Set cache-dir and start caching media")
+	flag.StringVar(&cacheLayoutTemplate, "cache-layout", "", "Cache directory layout template ({download_date}, {tweet_date}, {author}, {shard})")
+	flag.BoolVar(&relocateCacheMode, "relocate-cache", false, "Move cached files to the current cache layout and rewrite their paths")
+	flag.StringVar(&relocateTo, "relocate-to", "", "Cache root to move files into with -relocate-cache (default: current cache root)")
+	flag.BoolVar(&verifyCacheMode, "verify-cache", false, "Report missing, truncated, corrupt and orphaned cache files as JSON")
+	flag.BoolVar(&fixMode, "fix", false, "With -verify-cache, re-queue missing downloads and delete or adopt orphaned files")
+	flag.BoolVar(&rebuildFromCacheMode, "rebuild-from-cache", false, "Recreate media rows from the files in the cache directory (metadata from -f if given)")
 	flag.StringVar(&deleteCacheFile, "delete-cache", "", "Delete media cache files")
 	flag.BoolVar(&cachingMode, "caching", false, "Start caching media with default cache dir")
 	flag.BoolVar(&makeThumbnailMode, "make-thumbnails", false, "Start creating thumbnails for video media")
+	flag.BoolVar(&makePreviewMode, "make-previews", false, "Start creating animated previews and sprite sheets for video media")
+	flag.BoolVar(&probeMetadataMode, "probe-metadata", false, "Start probing metadata of cached media")
 	flag.BoolVar(&calcDiffHashMode, "calc-diffhash", false, "Starts calculating the media difference hash")
+	flag.StringVar(&hashAlgorithms, "hash-algorithms", "", "Comma-separated hash algorithms to calculate with -calc-diffhash besides dhash (ahash, phash, whash, dhash256, ...)")
+	flag.IntVar(&downloadConcurrency, "concurrency", 4, "Number of concurrent downloads while caching")
+	flag.IntVar(&hostConcurrency, "host-concurrency", 2, "Number of concurrent downloads per host while caching")
+	flag.Int64Var(&maxDownloadSize, "max-download-size", mediadata.DefaultMaxContentLength>>20, "Maximum size of a downloaded media file in MiB")
+	flag.BoolVar(&migrateMode, "migrate", false, "Apply pending database schema migrations")
+	flag.BoolVar(&migrateThumbnailsMode, "migrate-thumbnails", false, "Move thumbnails stored in the database into the blob store")
+	flag.StringVar(&listenAddr, "listen", "", "Start daemon as a standalone HTTP server on the address (e.g. :8080)")
 }
 
 func main() {
 	flag.Parse()
 
+	mediadata.DefaultDownloader.MaxContentLength = maxDownloadSize << 20
+
 	if flag.NFlag() > 0 {
+		if migrateMode {
+			log.Println("Start migrating database schema...")
+			err := migrate()
+			if err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+
+		if rebuildFromCacheMode {
+			log.Println("Start rebuilding database from cache...: " + cacheDir)
+			err := rebuildFromCache(cacheDir, fromFile)
+			if err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+
+		if verifyCacheMode {
+			log.Println("Start verifying cache...: " + cacheDir)
+			err := verifyCache(cacheDir, fixMode)
+			if err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+
+		if relocateCacheMode {
+			log.Println("Start relocating cache...: " + relocateTo)
+			err := relocateCache(cacheDir, relocateTo)
+			if err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+
+		if migrateThumbnailsMode {
+			log.Println("Start migrating thumbnails...: " + cacheDir)
+			err := migrateThumbnails(cacheDir)
+			if err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+
 		if len(fromFile) > 0 {
 			log.Println("Start caching media from file...: " + cacheDir)
 			err := cacheFromFile(cacheDir, fromFile)
@@ -35,25 +113,25 @@ func main() {
 		}
 
 		if len(deleteCacheFile) > 0 {
-			err := DeleteCacheFile(deleteCacheFile)
+			err := DeleteCacheFile(cacheDir, deleteCacheFile)
 			if err != nil {
 				log.Fatal(err)
 			}
 			return
 		}
 
-		if cachingMode || len(cacheDir) > 0 {
-			log.Println("Start caching media...: " + cacheDir)
-			err := cache(cacheDir)
+		if makeThumbnailMode {
+			log.Println("Start creating video thumbnails...: " + cacheDir)
+			err := createThumbnails(cacheDir)
 			if err != nil {
 				log.Fatal(err)
 			}
 			return
 		}
 
-		if makeThumbnailMode || len(cacheDir) > 0 {
-			log.Println("Start creating video thumbnails...: " + cacheDir)
-			err := createThumbnails(cacheDir)
+		if makePreviewMode {
+			log.Println("Start creating video previews...: " + cacheDir)
+			err := createPreviews(cacheDir)
 			if err != nil {
 				log.Fatal(err)
 			}
@@ -62,7 +140,34 @@ func main() {
 
 		if calcDiffHashMode {
 			log.Println("Starts calculating the media difference hash...: ")
-			err := calculateDiffHashs()
+			err := calculateDiffHashs(cacheDir)
+			if err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+
+		if probeMetadataMode {
+			log.Println("Start probing media metadata...")
+			err := probeMetadata(cacheDir)
+			if err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+
+		if len(listenAddr) > 0 {
+			log.Println("Start daemon...: " + listenAddr)
+			err := daemon(listenAddr)
+			if err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+
+		if cachingMode || len(cacheDir) > 0 {
+			log.Println("Start caching media...: " + cacheDir)
+			err := cache(cacheDir)
 			if err != nil {
 				log.Fatal(err)
 			}
@@ -74,7 +179,7 @@ func main() {
 	}
 
 	log.Println("Start daemon...")
-	err := daemon()
+	err := daemon("")
 	if err != nil {
 		log.Fatal(err)
 	}
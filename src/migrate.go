@@ -0,0 +1,88 @@
+package main
+
+import (
+	"datasource"
+	"fmt"
+	"log"
+)
+
+func migrate() (err error) {
+	connectConfig, err := GetConnectConfig()
+	if err != nil {
+		return
+	}
+
+	if connectConfig.Driver == datasource.DriverMemory {
+		err = fmt.Errorf("migrations are not supported by driver: %s", connectConfig.Driver)
+		return
+	}
+
+	conn, err := datasource.Open(connectConfig)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	version, err := conn.SchemaVersion()
+	if err != nil {
+		return
+	}
+	log.Printf("Schema version: %d (latest %d)\n", version, datasource.LatestSchemaVersion())
+
+	pending, err := conn.PendingMigrations()
+	if err != nil {
+		return
+	}
+
+	if len(pending) == 0 {
+		log.Println("No pending migrations")
+		return
+	}
+
+	for _, m := range pending {
+		log.Printf("Pending: %d %s\n", m.Version, m.Description)
+	}
+
+	applied, err := conn.Migrate()
+	for _, m := range applied {
+		log.Printf("Applied: %d %s\n", m.Version, m.Description)
+	}
+
+	return
+}
+
+func migrateThumbnails(cacheDir string) (err error) {
+	conn, err := GetConnection()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	blobs, err := getBlobStore(cacheDir)
+	if err != nil {
+		return
+	}
+
+	mediaIds, err := conn.GetLegacyThumbnailMediaIds()
+	if err != nil {
+		return
+	}
+
+	moved := 0
+	for _, mediaId := range mediaIds {
+		thumbnail, err := conn.GetThumbnailByID(mediaId)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		if err = storeThumbnail(conn, blobs, mediaId, thumbnail); err != nil {
+			log.Println(err)
+			continue
+		}
+		moved++
+	}
+
+	log.Printf("Moved %d of %d thumbnails to %s\n", moved, len(mediaIds), blobs.Root())
+
+	return
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"blobstore"
+	"errors"
+	"fmt"
+	"log"
+	"mediadata"
+	"os/exec"
+)
+
+func createPreviews(cacheDir string) (err error) {
+	runData, err := RunDaemon("caching.pid")
+	if err != nil {
+		return
+	}
+	defer runData.Close()
+
+	conn, err := GetConnection()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	animatedMediaList, err := conn.GetMediaWithoutPreview()
+	if err != nil {
+		return
+	}
+
+	if len(animatedMediaList) == 0 {
+		err = fmt.Errorf("no media without preview")
+		return
+	}
+
+	cacheRoot, err := getCacheRoot(cacheDir)
+	if err != nil {
+		return
+	}
+
+	blobs := newBlobStore(cacheRoot)
+
+	for _, animatedMedia := range animatedMediaList {
+		mediaPath := cacheFilePath(cacheRoot, animatedMedia.Path)
+
+		if animatedMedia.NeedsPreview {
+			preview, makeErr := mediadata.MakePreview(mediaPath, animatedMedia.DurationMillis, 0)
+			storeErr := storeGeneratedBlob(blobs, animatedMedia.Id, preview, makeErr, ".webp", conn.SetPreviewKey, conn.SetPreviewFailed)
+			if errors.Is(storeErr, exec.ErrNotFound) {
+				return storeErr
+			} else if storeErr != nil {
+				log.Println(storeErr)
+			} else if makeErr == nil {
+				log.Println("Preview created: " + mediaPath)
+			}
+		}
+
+		if animatedMedia.NeedsSprite {
+			sprite, makeErr := mediadata.MakeSprite(mediaPath, animatedMedia.DurationMillis, 0, 0)
+			storeErr := storeGeneratedBlob(blobs, animatedMedia.Id, sprite, makeErr, ".jpg", conn.SetSpriteKey, conn.SetSpriteFailed)
+			if errors.Is(storeErr, exec.ErrNotFound) {
+				return storeErr
+			} else if storeErr != nil {
+				log.Println(storeErr)
+			} else if makeErr == nil {
+				log.Println("Sprite sheet created: " + mediaPath)
+			}
+		}
+	}
+
+	return
+}
+
+// storeGeneratedBlob saves a generated preview or sprite sheet. A file ffmpeg
+// cannot process is marked as failed so later runs skip it; a missing ffmpeg
+// is returned as is.
+func storeGeneratedBlob(blobs *blobstore.BlobStore, mediaId string, data []byte, makeErr error, ext string, setKey func(mediaId string, key string) error, setFailed func(mediaId string) error) error {
+	if makeErr != nil {
+		if errors.Is(makeErr, exec.ErrNotFound) {
+			return makeErr
+		}
+		log.Println(makeErr)
+		return setFailed(mediaId)
+	}
+
+	key, err := blobs.Put(data, ext)
+	if err != nil {
+		return err
+	}
+	return setKey(mediaId, key)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"datasource"
+	"fmt"
+	"log"
+	"mediadata"
+)
+
+func probeMetadata(cacheDir string) (err error) {
+	runData, err := RunDaemon("caching.pid")
+	if err != nil {
+		return
+	}
+	defer runData.Close()
+
+	conn, err := GetConnection()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	cachedMediaList, err := conn.GetUnprobedMedia()
+	if err != nil {
+		return
+	}
+
+	cacheRoot, err := getCacheRoot(cacheDir)
+	if err != nil {
+		return
+	}
+
+	if len(cachedMediaList) == 0 {
+		err = fmt.Errorf("no unprobed media")
+		return
+	}
+
+	probed := 0
+	for _, cachedMedia := range cachedMediaList {
+		if err := recordMetadata(conn, cachedMedia.Id, cacheFilePath(cacheRoot, cachedMedia.Path), cachedMedia.Type); err != nil {
+			log.Println(err)
+			continue
+		}
+		probed++
+	}
+
+	log.Printf("Probed %d of %d media\n", probed, len(cachedMediaList))
+
+	return
+}
+
+func recordMetadata(conn datasource.Store, mediaId string, mediaPath string, mediaType string) (err error) {
+	metadata, err := mediadata.ProbeMetadata(mediaPath, mediaType)
+	if err != nil {
+		return
+	}
+
+	return conn.SetMediaMetadata(mediaId, metadata.Width, metadata.Height, metadata.DurationMillis, metadata.Codec, metadata.Bitrate, metadata.FrameRate)
+}
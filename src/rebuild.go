@@ -0,0 +1,145 @@
+package main
+
+import (
+	"blobstore"
+	"datasource"
+	"fmt"
+	"log"
+	"mediadata"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func rebuildFromCache(cacheDir string, fromFile string) (err error) {
+	runData, err := RunDaemon("caching.pid")
+	if err != nil {
+		return
+	}
+	defer runData.Close()
+
+	conn, err := GetConnection()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	cacheRoot, err := getCacheRoot(cacheDir)
+	if err != nil {
+		return
+	}
+
+	exported := map[string]mediadata.MediaData{}
+	if fromFile != "" {
+		var media []mediadata.MediaData
+		media, err = mediadata.ParseMediaDataFromFile(fromFile)
+		if err != nil {
+			return
+		}
+		for _, m := range media {
+			filename, err := m.SourceFilename()
+			if err != nil {
+				continue
+			}
+			exported[fileStem(filename)] = m
+		}
+	}
+
+	mediaRecordList, err := conn.GetMediaWithCache()
+	if err != nil {
+		return
+	}
+
+	referenced := map[string]bool{}
+	for _, mediaRecord := range mediaRecordList {
+		referenced[filepath.Clean(cacheFilePath(cacheRoot, mediaRecord.CachePath.String))] = true
+	}
+
+	files, err := findOrphans(cacheRoot, referenced)
+	if err != nil {
+		return
+	}
+
+	blobs := newBlobStore(cacheRoot)
+
+	var fromExport, inferred, failed int
+	for _, file := range files {
+		if strings.HasSuffix(file, "_thumb.jpg") {
+			continue
+		}
+
+		m, ok := exported[fileStem(filepath.Base(file))]
+		if !ok {
+			var inferErr error
+			m, inferErr = inferMediaData(file)
+			if inferErr != nil {
+				log.Println(inferErr)
+				failed++
+				continue
+			}
+		}
+
+		if rebuildErr := rebuildMedia(conn, blobs, cacheRoot, m, file); rebuildErr != nil {
+			log.Println(rebuildErr)
+			failed++
+			continue
+		}
+
+		if ok {
+			fromExport++
+		} else {
+			inferred++
+		}
+		log.Println("Rebuilt: " + m.Id + " " + file)
+	}
+
+	log.Printf("Rebuilding finished: %d from export, %d inferred, %d failed\n", fromExport, inferred, failed)
+
+	return
+}
+
+func rebuildMedia(conn datasource.Store, blobs *blobstore.BlobStore, cacheRoot string, m mediadata.MediaData, file string) (err error) {
+	cacheData, err := mediadata.CacheDataFromFile(file, m.Type)
+	if err != nil {
+		return
+	}
+
+	err = upsertMediaData(conn, []mediadata.MediaData{m})
+	if err != nil {
+		return
+	}
+
+	if !saveCacheData(conn, blobs, cacheRoot, m, cacheData) {
+		err = fmt.Errorf("failed to save cache data: %s", m.Id)
+	}
+	return
+}
+
+func inferMediaData(file string) (m mediadata.MediaData, err error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return
+	}
+
+	mimeType, err := mediadata.DetectMimeTypeFromFile(file)
+	if err != nil {
+		return
+	}
+
+	filename := filepath.Base(file)
+	ext := strings.TrimPrefix(filepath.Ext(filename), ".")
+	m.Id = fileStem(filename)
+	m.Timestamp = uint64(info.ModTime().UnixMilli())
+
+	switch {
+	case strings.HasPrefix(mimeType, "image/"):
+		m.Type = "photo"
+		m.Url = "https://pbs.twimg.com/media/" + m.Id + "?format=" + ext + "&name=orig"
+	case strings.HasPrefix(mimeType, "video/"):
+		err = fmt.Errorf("cannot infer source url of video or animated GIF, rebuild with -f: %s", file)
+	default:
+		err = fmt.Errorf("cannot infer media type: %s: %s", file, mimeType)
+	}
+
+	return
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"mediadata"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+type fileMove struct {
+	from string
+	to   string
+}
+
+func relocateCache(cacheDir string, relocateTo string) (err error) {
+	runData, err := RunDaemon("caching.pid")
+	if err != nil {
+		return
+	}
+	defer runData.Close()
+
+	conn, err := GetConnection()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	sourceRoot, err := getCacheRoot(cacheDir)
+	if err != nil {
+		return
+	}
+
+	targetRoot := sourceRoot
+	if relocateTo != "" {
+		targetRoot, err = filepath.Abs(relocateTo)
+		if err != nil {
+			return
+		}
+	}
+
+	layout, err := getCacheLayout(cacheLayoutTemplate)
+	if err != nil {
+		return
+	}
+
+	mediaRecordList, err := conn.GetMediaWithCache()
+	if err != nil {
+		return
+	}
+
+	cachePaths := map[string]string{}
+	moves := []fileMove{}
+	for _, mediaRecord := range mediaRecordList {
+		from := cacheFilePath(sourceRoot, mediaRecord.CachePath.String)
+		info, statErr := os.Stat(from)
+		if statErr != nil {
+			log.Println(statErr)
+			continue
+		}
+
+		m := mediadata.MediaData{
+			Id:        mediaRecord.MediaId,
+			ParentUrl: mediaRecord.ParentUrl,
+			Timestamp: mediaRecord.Timestamp,
+		}
+		to := filepath.Join(targetRoot, layout.dir(m, info.ModTime()), filepath.Base(from))
+
+		if from != to {
+			if err = moveFile(from, to); err != nil {
+				rollbackMoves(moves)
+				return
+			}
+			moves = append(moves, fileMove{from, to})
+
+			if mediaRecord.Type != "photo" {
+				thumbFrom := legacyThumbnailPath(from)
+				if _, statErr := os.Stat(thumbFrom); statErr == nil {
+					thumbTo := legacyThumbnailPath(to)
+					if err = moveFile(thumbFrom, thumbTo); err != nil {
+						rollbackMoves(moves)
+						return
+					}
+					moves = append(moves, fileMove{thumbFrom, thumbTo})
+				}
+			}
+		}
+
+		cachePath := relativeCachePath(targetRoot, to)
+		if cachePath != mediaRecord.CachePath.String {
+			cachePaths[mediaRecord.MediaId] = cachePath
+		}
+	}
+
+	if targetRoot != sourceRoot {
+		var blobMoves []fileMove
+		blobMoves, err = moveBlobs(newBlobStore(sourceRoot).Root(), newBlobStore(targetRoot).Root())
+		moves = append(moves, blobMoves...)
+		if err != nil {
+			rollbackMoves(moves)
+			return
+		}
+	}
+
+	if len(cachePaths) > 0 {
+		if err = conn.SetCachePaths(cachePaths); err != nil {
+			rollbackMoves(moves)
+			return
+		}
+	}
+
+	log.Printf("Relocated %d files, rewrote %d paths\n", len(moves), len(cachePaths))
+	if targetRoot != sourceRoot {
+		log.Println("Set CacheDir in config.json to: " + targetRoot)
+	}
+
+	return
+}
+
+// moveBlobs moves the blob store along with the media files. Blobs are
+// content-addressed, so one already present in the target is left in place.
+func moveBlobs(sourceRoot string, targetRoot string) (moves []fileMove, err error) {
+	err = filepath.WalkDir(sourceRoot, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p == sourceRoot && os.IsNotExist(err) {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(sourceRoot, p)
+		if err != nil {
+			return err
+		}
+		to := filepath.Join(targetRoot, rel)
+		if _, err := os.Stat(to); err == nil {
+			return nil
+		}
+		if err := moveFile(p, to); err != nil {
+			return err
+		}
+		moves = append(moves, fileMove{p, to})
+		return nil
+	})
+	return
+}
+
+func legacyThumbnailPath(mediaPath string) string {
+	return strings.TrimSuffix(mediaPath, filepath.Ext(mediaPath)) + "_thumb.jpg"
+}
+
+func moveFile(from string, to string) (err error) {
+	if _, err = os.Stat(to); err == nil {
+		return fmt.Errorf("relocation target already exists: %s", to)
+	}
+
+	if err = os.MkdirAll(filepath.Dir(to), os.ModePerm); err != nil {
+		return
+	}
+
+	err = os.Rename(from, to)
+	if err == nil || !errors.Is(err, syscall.EXDEV) {
+		return
+	}
+
+	if err = copyFile(from, to); err != nil {
+		os.Remove(to)
+		return
+	}
+
+	return os.Remove(from)
+}
+
+func copyFile(from string, to string) (err error) {
+	in, err := os.Open(from)
+	if err != nil {
+		return
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return
+	}
+
+	out, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode())
+	if err != nil {
+		return
+	}
+
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return
+	}
+
+	if err = out.Close(); err != nil {
+		return
+	}
+
+	return os.Chtimes(to, info.ModTime(), info.ModTime())
+}
+
+func rollbackMoves(moves []fileMove) {
+	for i := len(moves) - 1; i >= 0; i-- {
+		if err := moveFile(moves[i].to, moves[i].from); err != nil {
+			log.Println(err)
+		}
+	}
+}
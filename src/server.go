@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+const (
+	serverReadHeaderTimeout = 10 * time.Second
+	serverReadTimeout       = 60 * time.Second
+	serverWriteTimeout      = 5 * time.Minute
+	serverIdleTimeout       = 2 * time.Minute
+	serverShutdownTimeout   = 30 * time.Second
+)
+
+func serve(addr string, handler http.Handler) (err error) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: serverReadHeaderTimeout,
+		ReadTimeout:       serverReadTimeout,
+		WriteTimeout:      serverWriteTimeout,
+		IdleTimeout:       serverIdleTimeout,
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		log.Println("Listen: " + addr)
+		serveErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err = <-serveErr:
+		if errors.Is(err, http.ErrServerClosed) {
+			err = nil
+		}
+		return
+	case <-ctx.Done():
+	}
+
+	log.Println("Shutting down server...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), serverShutdownTimeout)
+	defer cancel()
+
+	err = server.Shutdown(shutdownCtx)
+	return
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"blobstore"
+	"crypto/sha1"
+	"database/sql"
+	"datasource"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"mediadata"
+	"net/http"
+	"os"
+	"strings"
+)
+
+var thumbnailWidths = []uint{mediadata.DefaultThumbnailWidth, 320, 480, 640}
+
+const thumbnailCacheControl = "public, max-age=86400"
+
+const thumbnailBlobExt = ".jpg"
+
+func isAllowedThumbnailWidth(width uint) bool {
+	for _, w := range thumbnailWidths {
+		if w == width {
+			return true
+		}
+	}
+	return false
+}
+
+func getThumbnail(conn datasource.Store, blobs *blobstore.BlobStore, cacheRoot string, id string, width uint) (thumbnail []byte, err error) {
+	if !isAllowedThumbnailWidth(width) {
+		return nil, NewDaemonError(nil, http.StatusBadRequest, fmt.Sprintf("unsupported thumbnail width: %d", width))
+	}
+
+	if width == mediadata.DefaultThumbnailWidth {
+		key, err := conn.GetThumbnailKeyByID(id)
+		if err != nil {
+			return nil, NewDaemonError(err, http.StatusNotFound, "")
+		}
+		if key != "" {
+			return readBlob(blobs, key)
+		}
+
+		thumbnail, err = conn.GetThumbnailByID(id)
+		if err != nil {
+			return nil, NewDaemonError(err, http.StatusNotFound, "")
+		}
+		if len(thumbnail) == 0 {
+			return nil, NewDaemonError(nil, http.StatusNotFound, "no thumbnail")
+		}
+		return thumbnail, nil
+	}
+
+	key, err := conn.GetThumbnailKeyBySize(id, width)
+	if err == nil {
+		thumbnail, err = blobs.Get(key)
+		if err == nil {
+			return
+		}
+		log.Println(err)
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return
+	}
+
+	mediaRecord, err := conn.GetMediaByID(id)
+	if err != nil {
+		return nil, NewDaemonError(err, http.StatusNotFound, "")
+	}
+	if !mediaRecord.CachePath.Valid {
+		return nil, NewDaemonError(nil, http.StatusNotFound, "no cache")
+	}
+
+	thumbnail, err = mediadata.MakeThumbnailForType(cacheFilePath(cacheRoot, mediaRecord.CachePath.String), mediaRecord.Type, width)
+	if err != nil {
+		return nil, NewDaemonError(err, http.StatusInternalServerError, "Failed to create thumbnail")
+	}
+
+	key, err = blobs.Put(thumbnail, thumbnailBlobExt)
+	if err != nil {
+		return
+	}
+
+	err = conn.SetThumbnailKeyBySize(id, width, key)
+	return
+}
+
+func readBlob(blobs *blobstore.BlobStore, key string) (data []byte, err error) {
+	data, err = blobs.Get(key)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, NewDaemonError(err, http.StatusNotFound, "no content")
+		}
+		return nil, NewDaemonError(err, http.StatusInternalServerError, "Failed to read content")
+	}
+	return
+}
+
+func storeThumbnail(conn datasource.Store, blobs *blobstore.BlobStore, mediaId string, thumbnail []byte) (err error) {
+	key, err := blobs.Put(thumbnail, thumbnailBlobExt)
+	if err != nil {
+		return
+	}
+
+	return conn.SetThumbnailKey(mediaId, key)
+}
+
+func contentETag(content []byte) string {
+	sum := sha1.Sum(content)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func matchETag(ifNoneMatch string, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+func writeCacheableContent(w http.ResponseWriter, r *http.Request, contentType string, content []byte) {
+	etag := contentETag(content)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", thumbnailCacheControl)
+
+	if matchETag(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Write(content)
+}
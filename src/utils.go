@@ -22,18 +22,77 @@ func ReadConnectConfig(confName string) (cc datasource.ConnectConfig, err error)
 	return
 }
 
-func GetConnection() (conn *datasource.Database, err error) {
+func GetConnectConfig() (connectConfig datasource.ConnectConfig, err error) {
 	execPath, err := ExecPath("connect.json")
 	if err != nil {
 		return
 	}
 
-	connectConfig, err := ReadConnectConfig(execPath)
+	databaseUrl := os.Getenv("DATABASE_URL")
+
+	connectConfig, err = ReadConnectConfig(execPath)
+	if err != nil {
+		if !os.IsNotExist(err) || databaseUrl == "" {
+			return
+		}
+		err = nil
+	}
+
+	if databaseUrl != "" {
+		connectConfig.Driver = datasource.DriverPostgres
+		connectConfig.Url = databaseUrl
+	}
+
+	if connectConfig.Driver == datasource.DriverSQLite && !filepath.IsAbs(connectConfig.Dbname) {
+		connectConfig.Dbname, err = ExecPath(connectConfig.Dbname)
+		if err != nil {
+			return
+		}
+	}
+
+	err = connectConfig.Validate()
+	return
+}
+
+type AppConfig struct {
+	CacheDir       string
+	CacheLayout    string
+	HashAlgorithms []string
+}
+
+func GetAppConfig() (appConfig AppConfig, err error) {
+	execPath, err := ExecPath("config.json")
+	if err != nil {
+		return
+	}
+
+	in, err := os.ReadFile(execPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+
+	err = json.Unmarshal(in, &appConfig)
+	if err != nil {
+		return
+	}
+
+	if appConfig.CacheDir != "" && !filepath.IsAbs(appConfig.CacheDir) {
+		appConfig.CacheDir, err = ExecPath(appConfig.CacheDir)
+	}
+
+	return
+}
+
+func GetConnection() (conn datasource.Store, err error) {
+	connectConfig, err := GetConnectConfig()
 	if err != nil {
 		return
 	}
 
-	conn, err = datasource.Connect(connectConfig)
+	conn, err = datasource.OpenStore(connectConfig)
 	if err != nil {
 		return
 	}
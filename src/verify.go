@@ -0,0 +1,292 @@
+package main
+
+import (
+	"blobstore"
+	"datasource"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"mediadata"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	issueMissing    = "missing"
+	issueTruncated  = "truncated"
+	issueCorrupt    = "corrupt"
+	issueOrphaned   = "orphaned"
+	issueUnusedBlob = "unused-blob"
+)
+
+type verifyIssue struct {
+	Kind         string `json:"kind"`
+	MediaId      string `json:"mediaId,omitempty"`
+	Path         string `json:"path"`
+	ExpectedSize int64  `json:"expectedSize,omitempty"`
+	ActualSize   int64  `json:"actualSize,omitempty"`
+	Error        string `json:"error,omitempty"`
+	Action       string `json:"action,omitempty"`
+}
+
+type verifyReport struct {
+	CacheRoot string         `json:"cacheRoot"`
+	Checked   int            `json:"checked"`
+	Summary   map[string]int `json:"summary"`
+	Issues    []verifyIssue  `json:"issues"`
+}
+
+func verifyCache(cacheDir string, fix bool) (err error) {
+	runData, err := RunDaemon("caching.pid")
+	if err != nil {
+		return
+	}
+	defer runData.Close()
+
+	conn, err := GetConnection()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	cacheRoot, err := getCacheRoot(cacheDir)
+	if err != nil {
+		return
+	}
+
+	mediaRecordList, err := conn.GetMediaWithCache()
+	if err != nil {
+		return
+	}
+
+	report := verifyReport{
+		CacheRoot: cacheRoot,
+		Summary:   map[string]int{},
+		Issues:    []verifyIssue{},
+	}
+	referenced := map[string]bool{}
+	probeAvailable := true
+
+	for _, mediaRecord := range mediaRecordList {
+		filePath := filepath.Clean(cacheFilePath(cacheRoot, mediaRecord.CachePath.String))
+		referenced[filePath] = true
+		if mediaRecord.Type != "photo" {
+			referenced[legacyThumbnailPath(filePath)] = true
+		}
+		report.Checked++
+
+		issue := verifyIssue{MediaId: mediaRecord.MediaId, Path: filePath}
+
+		info, statErr := os.Stat(filePath)
+		switch {
+		case statErr != nil:
+			issue.Kind = issueMissing
+			issue.Error = statErr.Error()
+		case mediaRecord.ContentLength.Valid && info.Size() != mediaRecord.ContentLength.Int64:
+			issue.Kind = issueTruncated
+			issue.ExpectedSize = mediaRecord.ContentLength.Int64
+			issue.ActualSize = info.Size()
+		case mediaRecord.Type != "photo" && !probeAvailable:
+			continue
+		default:
+			checkErr := mediadata.CheckMediaFile(filePath, mediaRecord.Type)
+			if checkErr == nil {
+				continue
+			}
+			if errors.Is(checkErr, exec.ErrNotFound) {
+				log.Println("ffprobe not found, skipping video decode checks")
+				probeAvailable = false
+				continue
+			}
+			issue.Kind = issueCorrupt
+			issue.Error = checkErr.Error()
+		}
+
+		// Truncated and corrupt files are only reported: the source may be
+		// gone, and a broken ffprobe would flag every video.
+		if fix && issue.Kind == issueMissing {
+			issue.Action = "requeued"
+			if err := conn.RequeueMedia(mediaRecord.MediaId); err != nil {
+				log.Println(err)
+				issue.Action = "failed: " + err.Error()
+			}
+		}
+
+		report.Summary[issue.Kind]++
+		report.Issues = append(report.Issues, issue)
+	}
+
+	orphans, err := findOrphans(cacheRoot, referenced)
+	if err != nil {
+		return
+	}
+
+	var adopter *orphanAdopter
+	if fix && len(orphans) > 0 {
+		adopter, err = newOrphanAdopter(conn, cacheRoot)
+		if err != nil {
+			return
+		}
+	}
+
+	for _, orphan := range orphans {
+		issue := verifyIssue{Kind: issueOrphaned, Path: orphan}
+		if fix {
+			issue.MediaId, issue.Action = adopter.adopt(orphan)
+		}
+		report.Summary[issueOrphaned]++
+		report.Issues = append(report.Issues, issue)
+	}
+
+	blobs := newBlobStore(cacheRoot)
+	unusedBlobs, err := findUnusedBlobs(conn, blobs)
+	if err != nil {
+		return
+	}
+
+	for _, key := range unusedBlobs {
+		blobPath, _ := blobs.Path(key)
+		issue := verifyIssue{Kind: issueUnusedBlob, Path: blobPath}
+		if fix {
+			issue.Action = "deleted"
+			if err := blobs.Delete(key); err != nil {
+				issue.Action = "failed: " + err.Error()
+			}
+		}
+		report.Summary[issueUnusedBlob]++
+		report.Issues = append(report.Issues, issue)
+	}
+
+	o, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return
+	}
+	fmt.Println(string(o))
+
+	return
+}
+
+func findOrphans(cacheRoot string, referenced map[string]bool) (orphans []string, err error) {
+	blobRoot := newBlobStore(cacheRoot).Root()
+
+	err = filepath.WalkDir(cacheRoot, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p == cacheRoot && os.IsNotExist(err) {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if d.IsDir() {
+			if p == blobRoot {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || strings.HasSuffix(p, mediadata.TempFileSuffix) {
+			return nil
+		}
+		if !referenced[filepath.Clean(p)] {
+			orphans = append(orphans, p)
+		}
+		return nil
+	})
+
+	return
+}
+
+// findUnusedBlobs lists blobs no media row references. Recent blobs are
+// skipped, since the daemon stores a blob before it records the key.
+func findUnusedBlobs(conn datasource.Store, blobs *blobstore.BlobStore) (unused []string, err error) {
+	keys, err := blobs.Keys()
+	if err != nil {
+		return
+	}
+
+	referencedKeys, err := conn.GetBlobKeys()
+	if err != nil {
+		return
+	}
+
+	referenced := map[string]bool{}
+	for _, key := range referencedKeys {
+		referenced[key] = true
+	}
+
+	for _, key := range keys {
+		if referenced[key] {
+			continue
+		}
+		blobPath, err := blobs.Path(key)
+		if err != nil {
+			continue
+		}
+		if info, err := os.Stat(blobPath); err != nil || time.Since(info.ModTime()) < staleTempFileAge {
+			continue
+		}
+		unused = append(unused, key)
+	}
+
+	return
+}
+
+type orphanAdopter struct {
+	conn       datasource.Store
+	blobs      *blobstore.BlobStore
+	cacheRoot  string
+	candidates map[string]mediadata.MediaData
+}
+
+func newOrphanAdopter(conn datasource.Store, cacheRoot string) (adopter *orphanAdopter, err error) {
+	mediaList, err := conn.GetNoCacheMedia()
+	if err != nil {
+		return
+	}
+
+	adopter = &orphanAdopter{
+		conn:       conn,
+		blobs:      newBlobStore(cacheRoot),
+		cacheRoot:  cacheRoot,
+		candidates: map[string]mediadata.MediaData{},
+	}
+	for _, media := range mediaList {
+		m := noCacheMediaToMediaData(media)
+		filename, err := m.SourceFilename()
+		if err != nil {
+			continue
+		}
+		adopter.candidates[fileStem(filename)] = m
+	}
+
+	return
+}
+
+func fileStem(filename string) string {
+	return strings.TrimSuffix(filename, filepath.Ext(filename))
+}
+
+func (a *orphanAdopter) adopt(orphan string) (mediaId string, action string) {
+	m, ok := a.candidates[fileStem(filepath.Base(orphan))]
+	if !ok {
+		if err := os.Remove(orphan); err != nil {
+			return "", "failed: " + err.Error()
+		}
+		return "", "deleted"
+	}
+
+	cacheData, err := mediadata.CacheDataFromFile(orphan, m.Type)
+	if err != nil {
+		return m.Id, "failed: " + err.Error()
+	}
+
+	if !saveCacheData(a.conn, a.blobs, a.cacheRoot, m, cacheData) {
+		return m.Id, "failed"
+	}
+
+	delete(a.candidates, fileStem(filepath.Base(orphan)))
+	return m.Id, "adopted"
+}